@@ -0,0 +1,244 @@
+package arming
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/psanford/rom-cam/config"
+)
+
+// Mode controls what happens when motion is detected.
+type Mode string
+
+const (
+	// Disarmed ignores motion.
+	Disarmed Mode = "disarmed"
+	// RecordOnly uploads footage but publishes nothing.
+	RecordOnly Mode = "record_only"
+	// Armed uploads footage and publishes events over mqtt.
+	Armed Mode = "armed"
+	// ArmedNotify also sends notifications.
+	ArmedNotify Mode = "armed_notify"
+)
+
+var Modes = []Mode{Disarmed, RecordOnly, Armed, ArmedNotify}
+
+func ParseMode(s string) (Mode, error) {
+	for _, m := range Modes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown arming mode %q", s)
+}
+
+func (m Mode) Records() bool {
+	return m != Disarmed
+}
+
+func (m Mode) Publishes() bool {
+	return m == Armed || m == ArmedNotify
+}
+
+func (m Mode) Notifies() bool {
+	return m == ArmedNotify
+}
+
+// Reasons a mode is in effect.
+const (
+	ReasonOverride = "override"
+	ReasonHome     = "home"
+	ReasonSchedule = "schedule"
+	ReasonDefault  = "default"
+)
+
+// State is the effective mode and why it is in effect.
+type State struct {
+	Mode   Mode   `json:"mode"`
+	Reason string `json:"reason"`
+	// Source is who set an override, e.g. "http" or "mqtt".
+	Source string `json:"source,omitempty"`
+	// Until is when an override expires. It is nil for overrides
+	// without an expiry and for other reasons.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+func (s State) equal(o State) bool {
+	if s.Mode != o.Mode || s.Reason != o.Reason || s.Source != o.Source {
+		return false
+	}
+	if s.Until == nil || o.Until == nil {
+		return s.Until == o.Until
+	}
+	return s.Until.Equal(*o.Until)
+}
+
+func (s State) String() string {
+	if s.Source != "" {
+		return fmt.Sprintf("%s (%s by %s)", s.Mode, s.Reason, s.Source)
+	}
+	return fmt.Sprintf("%s (%s)", s.Mode, s.Reason)
+}
+
+type override struct {
+	mode   Mode
+	source string
+	until  time.Time
+}
+
+// Manager resolves the effective mode from, in order of precedence, a
+// manual override, presence, the weekly schedule and the default mode.
+type Manager struct {
+	defaultMode Mode
+	homeMode    Mode
+	schedule    []scheduleEntry
+	loc         *time.Location
+	onChange    func(State)
+
+	mu       sync.Mutex
+	override *override
+	home     bool
+	last     State
+}
+
+type scheduleEntry struct {
+	window config.ArmingWindow
+	mode   Mode
+}
+
+func NewManager(conf config.Arming, loc *time.Location, onChange func(State)) (*Manager, error) {
+	m := &Manager{
+		defaultMode: ArmedNotify,
+		homeMode:    Disarmed,
+		loc:         loc,
+		onChange:    onChange,
+	}
+
+	var err error
+	if conf.DefaultMode != "" {
+		m.defaultMode, err = ParseMode(conf.DefaultMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.HomeMode != "" {
+		m.homeMode, err = ParseMode(conf.HomeMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, w := range conf.Schedule {
+		mode, err := ParseMode(w.Mode)
+		if err != nil {
+			return nil, err
+		}
+		m.schedule = append(m.schedule, scheduleEntry{window: w, mode: mode})
+	}
+
+	m.last = m.resolve(time.Now())
+	return m, nil
+}
+
+// State returns the mode in effect now.
+func (m *Manager) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.resolve(time.Now())
+}
+
+// SetHome records if someone is home.
+func (m *Manager) SetHome(home bool) {
+	m.mu.Lock()
+	m.home = home
+	m.mu.Unlock()
+	m.check()
+}
+
+// Home reports if someone is home, as last set by SetHome.
+func (m *Manager) Home() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.home
+}
+
+// Override forces mode until d has passed. A zero d never expires.
+func (m *Manager) Override(mode Mode, d time.Duration, source string) {
+	o := &override{
+		mode:   mode,
+		source: source,
+	}
+	if d > 0 {
+		o.until = time.Now().Add(d)
+	}
+
+	m.mu.Lock()
+	m.override = o
+	m.mu.Unlock()
+	m.check()
+}
+
+// ClearOverride returns to the presence/schedule/default mode.
+func (m *Manager) ClearOverride() {
+	m.mu.Lock()
+	m.override = nil
+	m.mu.Unlock()
+	m.check()
+}
+
+// Run watches for schedule transitions and override expiry until ctx is
+// done.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *Manager) check() {
+	m.mu.Lock()
+	state := m.resolve(time.Now())
+	changed := !state.equal(m.last)
+	m.last = state
+	m.mu.Unlock()
+
+	if changed && m.onChange != nil {
+		m.onChange(state)
+	}
+}
+
+// resolve must be called with mu held.
+func (m *Manager) resolve(now time.Time) State {
+	if o := m.override; o != nil {
+		if o.until.IsZero() || now.Before(o.until) {
+			st := State{Mode: o.mode, Reason: ReasonOverride, Source: o.source}
+			if !o.until.IsZero() {
+				until := o.until
+				st.Until = &until
+			}
+			return st
+		}
+		m.override = nil
+	}
+
+	if m.home {
+		return State{Mode: m.homeMode, Reason: ReasonHome}
+	}
+
+	local := now.In(m.loc)
+	for _, e := range m.schedule {
+		if e.window.Active(local) {
+			return State{Mode: e.mode, Reason: ReasonSchedule}
+		}
+	}
+
+	return State{Mode: m.defaultMode, Reason: ReasonDefault}
+}
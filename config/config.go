@@ -1,22 +1,46 @@
 package config
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/psanford/rom-cam/schedule"
 )
 
 type Config struct {
-	Name                   string   `toml:"name"`
-	FFMPEGPath             string   `toml:"ffmpeg_path"`
-	Device                 string   `toml:"device"`
-	SaveTSDir              string   `toml:"save_ts_dir"`
-	Bucket                 string   `toml:"bucket"`
-	WebhookURL             string   `toml:"webhook_url"`
-	LoadKernelModule       bool     `toml:"load_kernel_module"`
-	AWSCreds               *AWSCred `toml:"aws_creds"`
-	WebserverListenAddr    string   `toml:"webserver_listen_address"`
-	DisableRecordingForIPs []string `toml:"disable_recording_for_ips"`
+	Name                   string     `toml:"name"`
+	FFMPEGPath             string     `toml:"ffmpeg_path"`
+	Device                 string     `toml:"device"`
+	SaveTSDir              string     `toml:"save_ts_dir"`
+	Bucket                 string     `toml:"bucket"`
+	WebhookURL             string     `toml:"webhook_url"`
+	SlackBotToken          string     `toml:"slack_bot_token"`
+	SlackChannel           string     `toml:"slack_channel"`
+	LoadKernelModule       bool       `toml:"load_kernel_module"`
+	AWSCreds               *AWSCred   `toml:"aws_creds"`
+	WebserverListenAddr    string     `toml:"webserver_listen_address"`
+	WebAuth                *WebAuth   `toml:"web_auth"`
+	WebTLS                 *WebTLS    `toml:"web_tls"`
+	DisableRecordingForIPs []string   `toml:"disable_recording_for_ips"`
+	Retention              Retention  `toml:"retention"`
+	Continuous             Continuous `toml:"continuous"`
+	Webhooks               []Webhook  `toml:"webhook"`
+	MQTT                   *MQTT      `toml:"mqtt"`
+	Emails                 []Email    `toml:"email"`
+	Notify                 Notify     `toml:"notify"`
+	Ntfy                   []Ntfy     `toml:"ntfy"`
+	Gotify                 []Gotify   `toml:"gotify"`
+	Telegram               []Telegram `toml:"telegram"`
+	Matrix                 []Matrix   `toml:"matrix"`
+	Presence               Presence   `toml:"presence"`
+	Arming                 Arming     `toml:"arming"`
+	Zones                  []Zone     `toml:"zone"`
+	Ring                   Ring       `toml:"ring"`
+	MJPEG                  MJPEG      `toml:"mjpeg"`
+	WebRTC                 *WebRTC    `toml:"webrtc"`
+	RTSP                   *RTSP      `toml:"rtsp"`
 }
 
 func (c *Config) NameForFile() string {
@@ -29,6 +53,303 @@ type AWSCred struct {
 	SecretAccessKey string `toml:"secret_access_key"`
 }
 
+// Retention controls how long uploaded footage is kept in the bucket.
+// Zero values disable the corresponding rule.
+type Retention struct {
+	// KeepDays deletes everything for an event older than this.
+	KeepDays int `toml:"keep_days"`
+	// ThumbnailsOnlyAfterDays deletes the video for an event older than
+	// this, leaving only the tiled image.
+	ThumbnailsOnlyAfterDays int `toml:"thumbnails_only_after_days"`
+	// MaxBytes caps the total bytes stored for this camera. The oldest
+	// objects are deleted first.
+	MaxBytes int64 `toml:"max_bytes"`
+	// Interval is how often the janitor runs.
+	Interval time.Duration `toml:"interval"`
+}
+
+func (r *Retention) Enabled() bool {
+	return r.KeepDays > 0 || r.ThumbnailsOnlyAfterDays > 0 || r.MaxBytes > 0
+}
+
+// Continuous records every segment to the bucket, independent of motion.
+type Continuous struct {
+	Enabled bool `toml:"enabled"`
+	// Schedule limits continuous recording to these windows. Empty means
+	// always record.
+	Schedule  schedule.Schedule `toml:"schedule"`
+	Retention Retention         `toml:"retention"`
+}
+
+// Notify controls how often notifications are sent. It applies to every
+// configured notifier.
+type Notify struct {
+	// Cooldown is the minimum time between new event notifications.
+	Cooldown time.Duration `toml:"cooldown"`
+	// AggregateWindow merges motion segments that occur within this
+	// duration of each other into a single event. Notifiers that can
+	// edit messages update the original message.
+	AggregateWindow time.Duration `toml:"aggregate_window"`
+	// QuietHours suppresses notifications during these windows.
+	QuietHours schedule.Schedule `toml:"quiet_hours"`
+	// DigestAt, if set ("HH:MM"), disables per event notifications and
+	// instead sends one summary per day at this time.
+	DigestAt string `toml:"digest_at"`
+}
+
+// Webhook is a generic JSON webhook notifier.
+type Webhook struct {
+	URL     string            `toml:"url"`
+	Headers map[string]string `toml:"headers"`
+	// Secret, if set, is used to sign the request body with HMAC-SHA256.
+	Secret string `toml:"secret"`
+	// Template is an optional text/template for the request body. It is
+	// executed with the notification. The default is the notification
+	// encoded as JSON.
+	Template string `toml:"template"`
+}
+
+// Email sends a message per event over SMTP.
+type Email struct {
+	Host     string   `toml:"host"`
+	Port     int      `toml:"port"`
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+	// TLS is one of "starttls" (default), "tls" or "none".
+	TLS                string `toml:"tls"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+	// Image is the attached image, either "tiled" (default) or "best_frame".
+	Image string `toml:"image"`
+}
+
+type Ntfy struct {
+	// Server defaults to https://ntfy.sh.
+	Server string `toml:"server"`
+	Topic  string `toml:"topic"`
+	// Token is an access token. Username and Password can be used for
+	// basic auth instead.
+	Token    string `toml:"token"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	// Image is the attached image, either "tiled" (default) or "best_frame".
+	Image string `toml:"image"`
+}
+
+type Gotify struct {
+	Server string `toml:"server"`
+	// Token is the gotify application token.
+	Token string `toml:"token"`
+}
+
+type Telegram struct {
+	// Token is the bot token from @BotFather.
+	Token string `toml:"token"`
+	// ChatID is the numeric chat id or @channelname to post to. Commands
+	// are only accepted from this chat.
+	ChatID string `toml:"chat_id"`
+	// Commands enables /arm, /disarm, /status and /snapshot.
+	Commands bool `toml:"commands"`
+	// AllowedUsers are the numeric telegram user ids whose commands are
+	// run. Required with Commands since anyone in a group chat could
+	// otherwise disarm the camera.
+	AllowedUsers []int64 `toml:"allowed_users"`
+	// Image is the posted image, either "best_frame" (default) or "tiled".
+	Image string `toml:"image"`
+	// APIURL defaults to https://api.telegram.org.
+	APIURL string `toml:"api_url"`
+}
+
+type Matrix struct {
+	// Homeserver is the base url, e.g. https://matrix.example.com.
+	Homeserver  string `toml:"homeserver"`
+	AccessToken string `toml:"access_token"`
+	// RoomID is the room to post to, e.g. !abc123:example.com. The bot
+	// user must already be joined. Commands are only accepted from this room.
+	RoomID string `toml:"room_id"`
+	// Commands enables /arm, /disarm, /status and /snapshot.
+	Commands bool `toml:"commands"`
+	// AllowedSenders are the user ids, e.g. @alice:example.com, whose
+	// commands are run. Required with Commands since anyone who can join
+	// the room could otherwise disarm the camera.
+	AllowedSenders []string `toml:"allowed_senders"`
+	// Image is the posted image, either "best_frame" (default) or "tiled".
+	Image string `toml:"image"`
+}
+
+// Ring controls how much recent video is kept in memory for the live
+// view and timeshift playback.
+type Ring struct {
+	// Duration of video to keep. Defaults to 30s if neither limit is set.
+	Duration time.Duration `toml:"duration"`
+	// MaxBytes caps the memory used by the ring.
+	MaxBytes int64 `toml:"max_bytes"`
+}
+
+// MJPEG configures the /snapshot.jpg and /mjpeg endpoints.
+type MJPEG struct {
+	// FPS is the frame rate of the mjpeg stream. Defaults to 5.
+	FPS int `toml:"fps"`
+}
+
+// WebRTC serves the live view over WebRTC, with WHEP signaling on the
+// webserver.
+type WebRTC struct {
+	// ListenAddr is the UDP address media is sent from. Every viewer
+	// shares this port. Default :8189.
+	ListenAddr string `toml:"listen_address"`
+	// Hosts are the addresses offered to browsers as ICE candidates.
+	// Defaults to the addresses of all interfaces. Add your public IP if
+	// the port is forwarded from outside your network.
+	Hosts []string `toml:"hosts"`
+}
+
+// RTSP serves the live stream over RTSP for NVRs and players like VLC.
+type RTSP struct {
+	// ListenAddr is the TCP address for RTSP. Default :8554.
+	ListenAddr string `toml:"listen_address"`
+	// RTPPort is the UDP port media is sent from to clients that ask for
+	// UDP transport. RTCP uses the next port. Default 8000.
+	RTPPort int `toml:"rtp_port"`
+	// Username and Password, if set, require basic auth.
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
+// Zone is a named rectangle of the 640x480 motion detection frame.
+// Events record which zones saw motion.
+type Zone struct {
+	Name   string `toml:"name"`
+	X      int    `toml:"x"`
+	Y      int    `toml:"y"`
+	Width  int    `toml:"width"`
+	Height int    `toml:"height"`
+}
+
+func (z Zone) Validate() error {
+	if z.Name == "" {
+		return fmt.Errorf("zone requires a name")
+	}
+	if z.X < 0 || z.Y < 0 || z.Width <= 0 || z.Height <= 0 || z.X+z.Width > 640 || z.Y+z.Height > 480 {
+		return fmt.Errorf("zone %s: rectangle must be inside the 640x480 frame", z.Name)
+	}
+	return nil
+}
+
+// WebAuth requires authentication for the webserver.
+type WebAuth struct {
+	// Users maps usernames to bcrypt password hashes. Generate a hash
+	// with rom-cam-cli hash-password.
+	Users map[string]string `toml:"users"`
+	// SessionSecret signs session cookies and share tokens. If unset a
+	// random secret is generated at boot, which logs everyone out on
+	// restart.
+	SessionSecret string `toml:"session_secret"`
+	// SessionTTL defaults to 30 days.
+	SessionTTL time.Duration `toml:"session_ttl"`
+}
+
+// WebTLS serves the webserver over https.
+type WebTLS struct {
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+	// SelfSigned generates a certificate in memory at boot instead of
+	// loading CertFile and KeyFile.
+	SelfSigned bool `toml:"self_signed"`
+	// Hosts are the DNS names and IPs for the self signed certificate.
+	// Defaults to the hostname and the addresses of all interfaces.
+	Hosts []string `toml:"hosts"`
+	// RedirectAddr, if set, listens for plain http on this address and
+	// redirects every request to https.
+	RedirectAddr string `toml:"redirect_address"`
+}
+
+// Arming controls what happens on motion. Modes are "disarmed",
+// "record_only", "armed" and "armed_notify".
+type Arming struct {
+	// DefaultMode applies when nothing else does. Default armed_notify.
+	DefaultMode string `toml:"default_mode"`
+	// HomeMode applies while someone is home. Default disarmed.
+	HomeMode string `toml:"home_mode"`
+	// Schedule sets the mode during weekly windows. The first matching
+	// window wins.
+	Schedule []ArmingWindow `toml:"schedule"`
+}
+
+type ArmingWindow struct {
+	schedule.Window
+	Mode string `toml:"mode"`
+}
+
+// Presence configures how we detect that someone is home. While anyone is
+// home the arming home mode applies.
+type Presence struct {
+	// Interval is how often providers are polled. Default 60s.
+	Interval time.Duration `toml:"interval"`
+	// AwayGrace is how long no provider may see a device before we
+	// switch to away. This smooths over phones that sleep their wifi.
+	AwayGrace time.Duration `toml:"away_grace"`
+
+	// PingIPs are checked with icmp echo. disable_recording_for_ips is
+	// added to this list.
+	PingIPs  []string         `toml:"ping_ips"`
+	ARP      *PresenceARP     `toml:"arp"`
+	DHCP     []PresenceDHCP   `toml:"dhcp"`
+	Router   []PresenceRouter `toml:"router"`
+	Endpoint *PresenceHTTP    `toml:"endpoint"`
+}
+
+func (p *Presence) Enabled() bool {
+	return len(p.PingIPs) > 0 || p.ARP != nil || len(p.DHCP) > 0 || len(p.Router) > 0 || p.Endpoint != nil
+}
+
+type PresenceARP struct {
+	// Path defaults to /proc/net/arp.
+	Path string   `toml:"path"`
+	MACs []string `toml:"macs"`
+	IPs  []string `toml:"ips"`
+}
+
+type PresenceDHCP struct {
+	// Path to a dnsmasq or ISC dhcpd lease file.
+	Path      string   `toml:"path"`
+	MACs      []string `toml:"macs"`
+	Hostnames []string `toml:"hostnames"`
+}
+
+type PresenceRouter struct {
+	// URL returns the router's client list.
+	URL      string            `toml:"url"`
+	Username string            `toml:"username"`
+	Password string            `toml:"password"`
+	Headers  map[string]string `toml:"headers"`
+	// Match is a list of strings (usually mac addresses) to look for in
+	// the response.
+	Match []string `toml:"match"`
+}
+
+// PresenceHTTP enables POST /presence on the webserver.
+type PresenceHTTP struct {
+	// Token must be sent as a bearer token or token query parameter.
+	Token string `toml:"token"`
+	// TTL expires a home report that is not refreshed. Zero means never.
+	TTL time.Duration `toml:"ttl"`
+}
+
+type MQTT struct {
+	// Broker is the broker url, e.g. tcp://localhost:1883 or ssl://host:8883.
+	Broker   string `toml:"broker"`
+	ClientID string `toml:"client_id"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	// TopicPrefix defaults to rom-cam/<camera name>.
+	TopicPrefix            string `toml:"topic_prefix"`
+	HomeAssistantDiscovery bool   `toml:"home_assistant_discovery"`
+	// DiscoveryPrefix defaults to homeassistant.
+	DiscoveryPrefix string `toml:"discovery_prefix"`
+}
+
 func LoadConfig(confPath string) (*Config, error) {
 	var c Config
 	_, err := toml.DecodeFile(confPath, &c)
@@ -44,5 +365,73 @@ func LoadConfig(confPath string) (*Config, error) {
 		c.Device = "/dev/video0"
 	}
 
+	if c.Retention.Interval == 0 {
+		c.Retention.Interval = time.Hour
+	}
+
+	if c.Continuous.Retention.Interval == 0 {
+		c.Continuous.Retention.Interval = time.Hour
+	}
+
+	if c.Ring.Duration == 0 && c.Ring.MaxBytes == 0 {
+		c.Ring.Duration = 30 * time.Second
+	}
+
+	if c.MJPEG.FPS <= 0 {
+		c.MJPEG.FPS = 5
+	}
+
+	if c.WebRTC != nil && c.WebRTC.ListenAddr == "" {
+		c.WebRTC.ListenAddr = ":8189"
+	}
+
+	if c.RTSP != nil {
+		if c.RTSP.ListenAddr == "" {
+			c.RTSP.ListenAddr = ":8554"
+		}
+		if c.RTSP.RTPPort == 0 {
+			c.RTSP.RTPPort = 8000
+		}
+	}
+
+	c.Presence.PingIPs = append(c.Presence.PingIPs, c.DisableRecordingForIPs...)
+	if c.Presence.Interval == 0 {
+		c.Presence.Interval = 60 * time.Second
+	}
+
+	if c.Presence.Endpoint != nil && c.Presence.Endpoint.Token == "" {
+		return nil, fmt.Errorf("presence endpoint requires a token")
+	}
+
+	if c.WebTLS != nil && !c.WebTLS.SelfSigned && (c.WebTLS.CertFile == "" || c.WebTLS.KeyFile == "") {
+		return nil, fmt.Errorf("web_tls requires cert_file and key_file or self_signed")
+	}
+
+	if err := c.Continuous.Schedule.Validate(); err != nil {
+		return nil, err
+	}
+
+	for _, w := range c.Arming.Schedule {
+		if err := w.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, z := range c.Zones {
+		if err := z.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Notify.QuietHours.Validate(); err != nil {
+		return nil, err
+	}
+
+	if c.Notify.DigestAt != "" {
+		if _, err := time.Parse("15:04", c.Notify.DigestAt); err != nil {
+			return nil, fmt.Errorf("notify digest_at: %w", err)
+		}
+	}
+
 	return &c, nil
 }
@@ -0,0 +1,103 @@
+package config
+
+// redacted replaces secrets in Redacted configs.
+const redacted = "REDACTED"
+
+// Redacted returns a copy of c with passwords, tokens and other secrets
+// replaced so it is safe to show over the api.
+func (c Config) Redacted() Config {
+	redact(&c.WebhookURL)
+	redact(&c.SlackBotToken)
+
+	if c.AWSCreds != nil {
+		creds := *c.AWSCreds
+		redact(&creds.SecretAccessKey)
+		c.AWSCreds = &creds
+	}
+
+	if c.WebAuth != nil {
+		auth := *c.WebAuth
+		auth.Users = redactMap(auth.Users)
+		redact(&auth.SessionSecret)
+		c.WebAuth = &auth
+	}
+
+	c.Webhooks = append([]Webhook(nil), c.Webhooks...)
+	for i := range c.Webhooks {
+		redact(&c.Webhooks[i].URL)
+		redact(&c.Webhooks[i].Secret)
+		c.Webhooks[i].Headers = redactMap(c.Webhooks[i].Headers)
+	}
+
+	if c.MQTT != nil {
+		mqtt := *c.MQTT
+		redact(&mqtt.Password)
+		c.MQTT = &mqtt
+	}
+
+	c.Emails = append([]Email(nil), c.Emails...)
+	for i := range c.Emails {
+		redact(&c.Emails[i].Password)
+	}
+
+	c.Ntfy = append([]Ntfy(nil), c.Ntfy...)
+	for i := range c.Ntfy {
+		redact(&c.Ntfy[i].Token)
+		redact(&c.Ntfy[i].Password)
+	}
+
+	c.Gotify = append([]Gotify(nil), c.Gotify...)
+	for i := range c.Gotify {
+		redact(&c.Gotify[i].Token)
+	}
+
+	c.Telegram = append([]Telegram(nil), c.Telegram...)
+	for i := range c.Telegram {
+		redact(&c.Telegram[i].Token)
+	}
+
+	c.Matrix = append([]Matrix(nil), c.Matrix...)
+	for i := range c.Matrix {
+		redact(&c.Matrix[i].AccessToken)
+	}
+
+	c.Presence.Router = append([]PresenceRouter(nil), c.Presence.Router...)
+	for i := range c.Presence.Router {
+		redact(&c.Presence.Router[i].Password)
+		c.Presence.Router[i].Headers = redactMap(c.Presence.Router[i].Headers)
+	}
+
+	if c.Presence.Endpoint != nil {
+		endpoint := *c.Presence.Endpoint
+		redact(&endpoint.Token)
+		c.Presence.Endpoint = &endpoint
+	}
+
+	if c.RTSP != nil {
+		rtsp := *c.RTSP
+		redact(&rtsp.Password)
+		c.RTSP = &rtsp
+	}
+
+	return c
+}
+
+// redact replaces a non-empty secret so it's still clear that it is set.
+func redact(s *string) {
+	if *s != "" {
+		*s = redacted
+	}
+}
+
+// redactMap keeps the keys of m but redacts every value.
+func redactMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		redact(&v)
+		out[k] = v
+	}
+	return out
+}
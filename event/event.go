@@ -0,0 +1,31 @@
+package event
+
+import "time"
+
+// Event is a single motion detection on one segment.
+type Event struct {
+	Camera string    `json:"camera"`
+	TS     time.Time `json:"ts"`
+	// Frames is the number of frames in the segment with motion.
+	Frames int `json:"frames"`
+	// BestFrame is the index of the frame with the largest diff.
+	BestFrame int `json:"best_frame"`
+	MaxDiff   int `json:"max_diff"`
+	// Zones are the configured zones that saw motion.
+	Zones []string `json:"zones,omitempty"`
+
+	// Mode is the arming mode in effect when the event was recorded and
+	// ModeReason explains why, e.g. "armed (schedule)".
+	Mode       string `json:"mode,omitempty"`
+	ModeReason string `json:"mode_reason,omitempty"`
+
+	// Storage keys for the uploaded footage. SegmentKey points into the
+	// continuous recording when the segment was captured in that mode.
+	SegmentKey string `json:"segment_key,omitempty"`
+	MP4Key     string `json:"mp4_key,omitempty"`
+	TiledKey   string `json:"tiled_key,omitempty"`
+	Continuous bool   `json:"continuous,omitempty"`
+	// Manual is set for recordings requested through the api rather than
+	// triggered by motion.
+	Manual bool `json:"manual,omitempty"`
+}
@@ -0,0 +1,106 @@
+// Package feed fans out live daemon activity, like motion scores and
+// state changes, to subscribers such as the webserver's event stream.
+package feed
+
+import (
+	"sync"
+	"time"
+)
+
+// Message types published by the daemon.
+const (
+	// TypeMotionScore is the diff for one frame of a segment as it is
+	// checked for motion.
+	TypeMotionScore = "motion_score"
+	// TypeSegment is a completed segment added to the ring.
+	TypeSegment = "segment"
+	// TypeEvent is a recorded motion or manual event.
+	TypeEvent = "event"
+	// TypeMotion is a motion start or stop.
+	TypeMotion = "motion"
+	// TypeArming is a change of arming mode.
+	TypeArming = "arming"
+	// TypePresence is a change between home and away.
+	TypePresence = "presence"
+	// TypeCaptureReset is a restart of the capture ffmpeg.
+	TypeCaptureReset = "capture_reset"
+)
+
+// Message is one published item. Data is encoded as json for clients.
+type Message struct {
+	Type string
+	TS   time.Time
+	Data interface{}
+}
+
+// MotionScore is the data for TypeMotionScore messages.
+type MotionScore struct {
+	// TS is the approximate capture time of the frame.
+	TS        time.Time `json:"ts"`
+	Frame     int       `json:"frame"`
+	Diff      int       `json:"diff"`
+	Threshold int       `json:"threshold"`
+	Zones     []string  `json:"zones,omitempty"`
+}
+
+// Segment is the data for TypeSegment messages.
+type Segment struct {
+	ID            string    `json:"id"`
+	Seq           int       `json:"seq"`
+	TS            time.Time `json:"ts"`
+	Duration      float64   `json:"duration"`
+	Frames        int       `json:"frames"`
+	Bytes         int       `json:"bytes"`
+	Discontinuity bool      `json:"discontinuity,omitempty"`
+}
+
+// Feed is a broadcast hub. Publishing never blocks; messages are dropped
+// for subscribers that fall behind.
+type Feed struct {
+	mu          sync.Mutex
+	subscribers map[chan Message]struct{}
+}
+
+func New() *Feed {
+	return &Feed{
+		subscribers: make(map[chan Message]struct{}),
+	}
+}
+
+// Publish sends a message to every subscriber.
+func (f *Feed) Publish(typ string, data interface{}) {
+	msg := Message{
+		Type: typ,
+		TS:   time.Now(),
+		Data: data,
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives messages published from now
+// on. The returned func unsubscribes and closes the channel.
+func (f *Feed) Subscribe(buffer int) (<-chan Message, func()) {
+	ch := make(chan Message, buffer)
+
+	f.mu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.subscribers, ch)
+			f.mu.Unlock()
+			close(ch)
+		})
+	}
+}
@@ -1,27 +1,45 @@
 module github.com/psanford/rom-cam
 
-go 1.19
+go 1.20
 
 require (
 	github.com/BurntSushi/toml v1.3.2
 	github.com/Comcast/gots v1.0.4
 	github.com/aws/aws-sdk-go v1.38.45
+	github.com/eclipse/paho.mqtt.golang v1.4.3
 	github.com/grafov/m3u8 v0.12.0
 	github.com/inconshreveable/log15 v0.0.0-20201112154412-8562bdadbbac
 	github.com/nareix/joy4 v0.0.0-20200507095837-05a4ffbb5369
 	github.com/paulstuart/ping v0.0.0-20140925212352-0345a9703e43
-	github.com/slack-go/slack v0.9.1
+	github.com/pion/dtls/v3 v3.0.6
+	github.com/pion/rtcp v1.2.14
+	github.com/pion/rtp v1.8.19
+	github.com/pion/sdp/v3 v3.0.10
+	github.com/pion/srtp/v3 v3.0.4
+	github.com/prometheus/client_golang v1.19.1
+	github.com/prometheus/client_model v0.5.0
+	github.com/slack-go/slack v0.15.0
 	github.com/spf13/cobra v1.7.0
-	golang.org/x/sys v0.12.0
+	golang.org/x/crypto v0.32.0
+	golang.org/x/sys v0.29.0
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/mattn/go-colorable v0.1.8 // indirect
 	github.com/mattn/go-isatty v0.0.12 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pion/logging v0.2.3 // indirect
+	github.com/pion/randutil v0.1.0 // indirect
+	github.com/pion/transport/v3 v3.0.7 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/sync v0.3.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -0,0 +1,150 @@
+// Package metrics defines the Prometheus metrics exported by the daemon
+// at /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/psanford/rom-cam/arming"
+	"github.com/psanford/rom-cam/segment"
+)
+
+const namespace = "romcam"
+
+var (
+	SegmentsCaptured = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "segments_captured_total",
+		Help:      "Segments captured from the camera.",
+	})
+	SegmentBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "segment_bytes",
+		Help:      "Size of captured segments.",
+		Buckets:   prometheus.ExponentialBuckets(64<<10, 2, 8),
+	})
+	SegmentDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "segment_duration_seconds",
+		Help:      "Duration of captured segments.",
+		Buckets:   []float64{1, 2, 5, 8, 9, 10, 11, 12, 15, 20},
+	})
+	FFmpegRestarts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ffmpeg_restarts_total",
+		Help:      "Restarts of the capture ffmpeg process.",
+	})
+
+	MotionDetectDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "motion_detect_duration_seconds",
+		Help:      "Time to check a segment for motion.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 8),
+	})
+	MotionDetectErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "motion_detect_errors_total",
+		Help:      "Motion checks that failed.",
+	})
+	MotionFrames = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "motion_frames",
+		Help:      "Frames with motion per checked segment.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	})
+	Events = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Recorded events by trigger (motion or manual).",
+	}, []string{"trigger"})
+
+	Uploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "Objects uploaded to storage by kind and result (success or error).",
+	}, []string{"kind", "result"})
+	UploadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes successfully uploaded to storage by kind.",
+	}, []string{"kind"})
+	UploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time to upload an object to storage by kind.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"kind"})
+
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Notifications by notifier and result (sent, updated, suppressed, queued, dropped or error).",
+	}, []string{"notifier", "result"})
+
+	Home = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "presence_home",
+		Help:      "1 if someone is home.",
+	})
+	ArmingMode = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "arming_mode",
+		Help:      "1 for the arming mode in effect.",
+	}, []string{"mode"})
+)
+
+// Result labels.
+const (
+	ResultSuccess    = "success"
+	ResultError      = "error"
+	ResultSent       = "sent"
+	ResultUpdated    = "updated"
+	ResultSuppressed = "suppressed"
+	ResultQueued     = "queued"
+	ResultDropped    = "dropped"
+)
+
+// RegisterRing exports the ring's occupancy.
+func RegisterRing(ring *segment.Ring) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ring_segments",
+		Help:      "Segments held in the in memory ring.",
+	}, func() float64 {
+		return float64(ring.Stats().Segments)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ring_bytes",
+		Help:      "Memory used by segments in the ring.",
+	}, func() float64 {
+		return float64(ring.Stats().Bytes)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ring_duration_seconds",
+		Help:      "Duration of video held in the ring.",
+	}, func() float64 {
+		return ring.Stats().Duration.Seconds()
+	})
+}
+
+// SetArmingMode marks mode as the one in effect.
+func SetArmingMode(mode arming.Mode) {
+	for _, m := range arming.Modes {
+		v := 0.0
+		if m == mode {
+			v = 1
+		}
+		ArmingMode.WithLabelValues(string(m)).Set(v)
+	}
+}
+
+// SetHome records the presence state.
+func SetHome(home bool) {
+	if home {
+		Home.Set(1)
+	} else {
+		Home.Set(0)
+	}
+}
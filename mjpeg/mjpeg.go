@@ -0,0 +1,218 @@
+// Package mjpeg decodes the live stream to jpegs for snapshot and mjpeg
+// clients.
+package mjpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/segment"
+)
+
+// idleTimeout is how long the decoder keeps running after the last
+// viewer leaves, so polling snapshot clients don't restart it each time.
+const idleTimeout = 30 * time.Second
+
+// Decoder runs a single ffmpeg process that decodes the live stream to
+// jpegs at a fixed rate while anyone is watching. All viewers share it.
+type Decoder struct {
+	live       *segment.Live
+	ffmpegPath string
+	fps        int
+	lgr        log15.Logger
+
+	mu        sync.Mutex
+	viewers   int
+	cancel    context.CancelFunc
+	idleTimer *time.Timer
+	frame     []byte
+	frameTS   time.Time
+	// changed is closed and replaced when a new frame is decoded.
+	changed chan struct{}
+}
+
+func NewDecoder(lgr log15.Logger, live *segment.Live, ffmpegPath string, fps int) *Decoder {
+	return &Decoder{
+		live:       live,
+		ffmpegPath: ffmpegPath,
+		fps:        fps,
+		lgr:        lgr,
+		changed:    make(chan struct{}),
+	}
+}
+
+func (d *Decoder) FPS() int {
+	return d.fps
+}
+
+// Acquire registers a viewer, starting the decoder if needed. Call
+// Release when done.
+func (d *Decoder) Acquire() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.viewers++
+	if d.idleTimer != nil {
+		d.idleTimer.Stop()
+		d.idleTimer = nil
+	}
+	if d.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.cancel = cancel
+		go d.run(ctx)
+	}
+}
+
+func (d *Decoder) Release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.viewers--
+	if d.viewers > 0 || d.cancel == nil {
+		return
+	}
+	d.idleTimer = time.AfterFunc(idleTimeout, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.viewers == 0 && d.cancel != nil {
+			d.cancel()
+			d.cancel = nil
+			d.frame = nil
+		}
+	})
+}
+
+// Frame returns the latest frame, or nil if none has been decoded yet,
+// along with a channel that is closed when the next frame is ready.
+func (d *Decoder) Frame() ([]byte, time.Time, <-chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.frame, d.frameTS, d.changed
+}
+
+// Snapshot returns the latest frame, starting the decoder and waiting for
+// a frame if necessary.
+func (d *Decoder) Snapshot(ctx context.Context) ([]byte, error) {
+	d.Acquire()
+	defer d.Release()
+
+	for {
+		frame, _, changed := d.Frame()
+		if frame != nil {
+			return frame, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (d *Decoder) setFrame(frame []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.frame = frame
+	d.frameTS = time.Now()
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// run restarts ffmpeg until ctx is done.
+func (d *Decoder) run(ctx context.Context) {
+	for {
+		err := d.decode(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		d.lgr.Error("mjpeg_decoder_err", "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (d *Decoder) decode(ctx context.Context) error {
+	packets, backlog, unsubscribe := d.live.SubscribeBacklog(4096)
+	defer unsubscribe()
+
+	// ffmpeg starts from the beginning of the current segment so it has
+	// an IDR frame. Frames from that backlog are old, so don't publish
+	// them; snapshots would otherwise get a frame from seconds ago.
+	skip := int(backlog.Seconds() * float64(d.fps))
+
+	cmd := exec.CommandContext(ctx, d.ffmpegPath,
+		"-fflags", "nobuffer",
+		"-f", "mpegts", "-i", "-",
+		"-vf", "fps="+strconv.Itoa(d.fps),
+		"-q:v", "5",
+		"-f", "mpjpeg", "-")
+	cmd.Stderr = io.Discard
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer stdin.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case pkt, ok := <-packets:
+				if !ok {
+					return
+				}
+				if _, err := stdin.Write(pkt); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// ffmpeg's mpjpeg muxer writes each frame as a multipart part with
+	// the boundary "ffmpeg"
+	mr := multipart.NewReader(stdout, "ffmpeg")
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			cmd.Wait()
+			if err == io.EOF {
+				err = errors.New("ffmpeg exited")
+			}
+			return err
+		}
+
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, part)
+		if err != nil {
+			cmd.Wait()
+			return err
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		d.setFrame(buf.Bytes())
+	}
+}
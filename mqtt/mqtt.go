@@ -0,0 +1,322 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/arming"
+	"github.com/psanford/rom-cam/config"
+)
+
+// Commands accepted on the command topic.
+const (
+	CommandArm      = "arm"
+	CommandDisarm   = "disarm"
+	CommandSnapshot = "snapshot"
+)
+
+// Handler performs the actions requested on the command topic.
+type Handler interface {
+	Armed() bool
+	// SetArmed overrides the arming mode until d has passed. A zero d
+	// never expires.
+	SetArmed(armed bool, d time.Duration)
+	ArmingState() arming.State
+	Snapshot() ([]byte, error)
+}
+
+// Client publishes camera state to an MQTT broker and listens for commands.
+type Client struct {
+	conf       config.MQTT
+	cameraName string
+	handler    Handler
+	client     paho.Client
+	lgr        log15.Logger
+
+	mu sync.Mutex
+	// available is whether the camera is capturing. It is published on
+	// the status topic, which is also the last will.
+	available bool
+}
+
+func New(lgr log15.Logger, conf config.MQTT, cameraName, cameraID string, handler Handler) *Client {
+	c := &Client{
+		conf:       conf,
+		cameraName: cameraName,
+		handler:    handler,
+		lgr:        lgr,
+	}
+
+	if c.conf.TopicPrefix == "" {
+		c.conf.TopicPrefix = "rom-cam/" + cameraID
+	}
+	if c.conf.ClientID == "" {
+		c.conf.ClientID = "rom-cam-" + cameraID
+	}
+	if c.conf.DiscoveryPrefix == "" {
+		c.conf.DiscoveryPrefix = "homeassistant"
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(c.conf.Broker).
+		SetClientID(c.conf.ClientID).
+		SetUsername(c.conf.Username).
+		SetPassword(c.conf.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10*time.Second).
+		SetWill(c.topic("status"), "offline", 1, true).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			lgr.Error("mqtt_connection_lost", "err", err)
+		})
+
+	c.client = paho.NewClient(opts)
+
+	return c
+}
+
+// Connect starts connecting to the broker in the background. The client
+// reconnects on its own if the connection is lost.
+func (c *Client) Connect() {
+	c.client.Connect()
+}
+
+func (c *Client) Close() {
+	c.publish("status", "offline", true)
+	c.client.Disconnect(1000)
+}
+
+func (c *Client) onConnect(client paho.Client) {
+	c.lgr.Info("mqtt_connected", "broker", c.conf.Broker)
+
+	c.mu.Lock()
+	available := c.available
+	c.mu.Unlock()
+	c.publish("status", availability(available), true)
+	c.PublishArming(c.handler.ArmingState())
+
+	tok := client.Subscribe(c.topic("command"), 1, c.onCommand)
+	go func() {
+		tok.Wait()
+		if err := tok.Error(); err != nil {
+			c.lgr.Error("mqtt_subscribe_err", "err", err)
+		}
+	}()
+
+	if c.conf.HomeAssistantDiscovery {
+		c.publishDiscovery()
+	}
+}
+
+func (c *Client) onCommand(_ paho.Client, msg paho.Message) {
+	fields := strings.Fields(strings.ToLower(string(msg.Payload())))
+	if len(fields) == 0 {
+		c.lgr.Error("mqtt_unknown_command", "cmd", "")
+		return
+	}
+	cmd := fields[0]
+	c.lgr.Info("mqtt_command", "cmd", cmd, "args", fields[1:])
+
+	switch cmd {
+	case CommandArm, CommandDisarm:
+		// an optional duration limits the override, e.g. "arm 2h"
+		var d time.Duration
+		if len(fields) > 1 {
+			var err error
+			d, err = time.ParseDuration(fields[1])
+			if err != nil || d < 0 {
+				c.lgr.Error("mqtt_command_invalid_duration", "cmd", cmd, "duration", fields[1])
+				return
+			}
+		}
+		c.handler.SetArmed(cmd == CommandArm, d)
+	case CommandSnapshot:
+		go func() {
+			img, err := c.handler.Snapshot()
+			if err != nil {
+				c.lgr.Error("mqtt_snapshot_err", "err", err)
+				return
+			}
+			c.PublishSnapshot(img)
+		}()
+	default:
+		c.lgr.Error("mqtt_unknown_command", "cmd", cmd)
+	}
+}
+
+// SetAvailable reports whether the camera is capturing. The status topic
+// goes offline while capture is failing or restarting, not only when the
+// daemon disconnects. It starts offline until the first segment.
+func (c *Client) SetAvailable(available bool) {
+	c.mu.Lock()
+	changed := c.available != available
+	c.available = available
+	c.mu.Unlock()
+
+	if changed {
+		c.lgr.Info("mqtt_availability", "available", available)
+		c.publish("status", availability(available), true)
+	}
+}
+
+func (c *Client) PublishMotion(motion bool) {
+	c.publish("motion", onOff(motion), true)
+}
+
+func (c *Client) PublishPresence(home bool) {
+	state := "away"
+	if home {
+		state = "home"
+	}
+	c.publish("presence", state, true)
+}
+
+// PublishArming publishes the armed switch state along with the full
+// mode and reason.
+func (c *Client) PublishArming(state arming.State) {
+	c.publish("armed", onOff(state.Mode != arming.Disarmed), true)
+	c.publish("mode", string(state.Mode), true)
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		c.lgr.Error("mqtt_marshal_arming_err", "err", err)
+		return
+	}
+	c.publish("arming", b, true)
+}
+
+func (c *Client) PublishSnapshot(jpg []byte) {
+	c.publish("snapshot", jpg, false)
+}
+
+func (c *Client) topic(name string) string {
+	return c.conf.TopicPrefix + "/" + name
+}
+
+func (c *Client) publish(name string, payload interface{}, retained bool) {
+	if !c.client.IsConnectionOpen() {
+		return
+	}
+	tok := c.client.Publish(c.topic(name), 1, retained, payload)
+	go func() {
+		tok.Wait()
+		if err := tok.Error(); err != nil {
+			c.lgr.Error("mqtt_publish_err", "topic", c.topic(name), "err", err)
+		}
+	}()
+}
+
+// publishDiscovery announces our entities using the Home Assistant
+// MQTT discovery protocol.
+func (c *Client) publishDiscovery() {
+	device := map[string]interface{}{
+		"identifiers": []string{c.conf.ClientID},
+		"name":        c.cameraName,
+		"model":       "rom-cam",
+	}
+	availability := c.topic("status")
+	uniq := func(s string) string {
+		return fmt.Sprintf("%s_%s", c.conf.ClientID, s)
+	}
+
+	entities := []struct {
+		component string
+		objectID  string
+		conf      map[string]interface{}
+	}{
+		{
+			component: "binary_sensor",
+			objectID:  "motion",
+			conf: map[string]interface{}{
+				"name":         c.cameraName + " motion",
+				"device_class": "motion",
+				"state_topic":  c.topic("motion"),
+			},
+		},
+		{
+			component: "binary_sensor",
+			objectID:  "presence",
+			conf: map[string]interface{}{
+				"name":         c.cameraName + " presence",
+				"device_class": "presence",
+				"state_topic":  c.topic("presence"),
+				"payload_on":   "home",
+				"payload_off":  "away",
+			},
+		},
+		{
+			component: "switch",
+			objectID:  "armed",
+			conf: map[string]interface{}{
+				"name":          c.cameraName + " armed",
+				"state_topic":   c.topic("armed"),
+				"command_topic": c.topic("command"),
+				"payload_on":    CommandArm,
+				"payload_off":   CommandDisarm,
+				"state_on":      "ON",
+				"state_off":     "OFF",
+			},
+		},
+		{
+			component: "sensor",
+			objectID:  "mode",
+			conf: map[string]interface{}{
+				"name":                  c.cameraName + " mode",
+				"state_topic":           c.topic("mode"),
+				"json_attributes_topic": c.topic("arming"),
+			},
+		},
+		{
+			component: "camera",
+			objectID:  "snapshot",
+			conf: map[string]interface{}{
+				"name":  c.cameraName,
+				"topic": c.topic("snapshot"),
+			},
+		},
+		{
+			component: "button",
+			objectID:  "take_snapshot",
+			conf: map[string]interface{}{
+				"name":          c.cameraName + " take snapshot",
+				"command_topic": c.topic("command"),
+				"payload_press": CommandSnapshot,
+			},
+		},
+	}
+
+	for _, e := range entities {
+		e.conf["unique_id"] = uniq(e.objectID)
+		e.conf["availability_topic"] = availability
+		e.conf["device"] = device
+
+		b, err := json.Marshal(e.conf)
+		if err != nil {
+			c.lgr.Error("mqtt_discovery_marshal_err", "err", err)
+			continue
+		}
+
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", c.conf.DiscoveryPrefix, e.component, c.conf.ClientID, e.objectID)
+		c.client.Publish(topic, 1, true, b)
+	}
+}
+
+func availability(available bool) string {
+	if available {
+		return "online"
+	}
+	return "offline"
+}
+
+func onOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
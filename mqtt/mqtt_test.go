@@ -0,0 +1,257 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/arming"
+	"github.com/psanford/rom-cam/config"
+)
+
+// testBroker is an in-process MQTT broker for a single client. It records
+// what the client sends and can publish to it.
+type testBroker struct {
+	t  *testing.T
+	ln net.Listener
+
+	connects   chan *packets.ConnectPacket
+	subscribed chan string
+	published  chan *packets.PublishPacket
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{
+		t:          t,
+		ln:         ln,
+		connects:   make(chan *packets.ConnectPacket, 4),
+		subscribed: make(chan string, 16),
+		published:  make(chan *packets.PublishPacket, 256),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go b.serve()
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (b *testBroker) serve() {
+	for {
+		nc, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conn = nc
+		b.mu.Unlock()
+		b.handle(nc)
+	}
+}
+
+func (b *testBroker) handle(nc net.Conn) {
+	defer nc.Close()
+	for {
+		cp, err := packets.ReadPacket(nc)
+		if err != nil {
+			return
+		}
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			b.connects <- p
+			b.write(packets.NewControlPacket(packets.Connack))
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = p.Qoss
+			b.write(ack)
+			for _, topic := range p.Topics {
+				b.subscribed <- topic
+			}
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				b.write(ack)
+			}
+			b.published <- p
+		case *packets.PingreqPacket:
+			b.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *testBroker) write(cp packets.ControlPacket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := cp.Write(b.conn); err != nil {
+		b.t.Errorf("broker write: %s", err)
+	}
+}
+
+// send publishes payload to the client, as if another client had.
+func (b *testBroker) send(topic, payload string) {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = []byte(payload)
+	b.write(p)
+}
+
+// next returns the next message the client publishes to topic, skipping
+// others.
+func (b *testBroker) next(topic string) *packets.PublishPacket {
+	b.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-b.published:
+			if p.TopicName == topic {
+				return p
+			}
+		case <-timeout:
+			b.t.Fatalf("nothing published to %s", topic)
+		}
+	}
+}
+
+type armCall struct {
+	armed bool
+	d     time.Duration
+}
+
+type testHandler struct {
+	armed    chan armCall
+	snapshot []byte
+}
+
+func (h *testHandler) Armed() bool {
+	return false
+}
+
+func (h *testHandler) SetArmed(armed bool, d time.Duration) {
+	h.armed <- armCall{armed: armed, d: d}
+}
+
+func (h *testHandler) ArmingState() arming.State {
+	return arming.State{Mode: arming.ArmedNotify, Reason: "schedule"}
+}
+
+func (h *testHandler) Snapshot() ([]byte, error) {
+	return h.snapshot, nil
+}
+
+func TestClient(t *testing.T) {
+	broker := newTestBroker(t)
+	handler := &testHandler{
+		armed:    make(chan armCall, 1),
+		snapshot: []byte("jpeg"),
+	}
+
+	lgr := log15.New()
+	lgr.SetHandler(log15.DiscardHandler())
+	c := New(lgr, config.MQTT{
+		Broker:                 broker.url(),
+		HomeAssistantDiscovery: true,
+	}, "Front Door", "front_door", handler)
+	c.Connect()
+	defer c.Close()
+
+	const prefix = "rom-cam/front_door/"
+
+	select {
+	case conn := <-broker.connects:
+		if conn.ClientIdentifier != "rom-cam-front_door" {
+			t.Errorf("client id = %q", conn.ClientIdentifier)
+		}
+		if !conn.WillFlag || conn.WillTopic != prefix+"status" || string(conn.WillMessage) != "offline" || !conn.WillRetain {
+			t.Errorf("will = %t %q %q retain %t", conn.WillFlag, conn.WillTopic, conn.WillMessage, conn.WillRetain)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client never connected")
+	}
+
+	// offline until capture produces a segment
+	status := broker.next(prefix + "status")
+	if string(status.Payload) != "offline" || !status.Retain {
+		t.Errorf("status = %q retain %t, want offline retained", status.Payload, status.Retain)
+	}
+	if p := broker.next(prefix + "armed"); string(p.Payload) != "ON" {
+		t.Errorf("armed = %q, want ON", p.Payload)
+	}
+	if p := broker.next(prefix + "mode"); string(p.Payload) != string(arming.ArmedNotify) {
+		t.Errorf("mode = %q", p.Payload)
+	}
+
+	disc := broker.next("homeassistant/switch/rom-cam-front_door/armed/config")
+	var conf map[string]interface{}
+	if err := json.Unmarshal(disc.Payload, &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf["command_topic"] != prefix+"command" || conf["availability_topic"] != prefix+"status" {
+		t.Errorf("discovery config = %v", conf)
+	}
+
+	select {
+	case topic := <-broker.subscribed:
+		if topic != prefix+"command" {
+			t.Errorf("subscribed to %q", topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client never subscribed")
+	}
+
+	// status only changes on transitions
+	c.SetAvailable(true)
+	c.SetAvailable(true)
+	c.SetAvailable(false)
+	for _, want := range []string{"online", "offline"} {
+		if p := broker.next(prefix + "status"); string(p.Payload) != want {
+			t.Errorf("status = %q, want %q", p.Payload, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		cmd  string
+		want armCall
+	}{
+		{" ARM\n", armCall{armed: true}},
+		{"disarm", armCall{armed: false}},
+		{"arm 2h", armCall{armed: true, d: 2 * time.Hour}},
+		{"disarm 90m", armCall{armed: false, d: 90 * time.Minute}},
+	} {
+		broker.send(prefix+"command", tt.cmd)
+		select {
+		case got := <-handler.armed:
+			if got != tt.want {
+				t.Errorf("%q: SetArmed(%t, %s), want (%t, %s)", tt.cmd, got.armed, got.d, tt.want.armed, tt.want.d)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q: SetArmed not called", tt.cmd)
+		}
+	}
+
+	broker.send(prefix+"command", "snapshot")
+	if p := broker.next(prefix + "snapshot"); string(p.Payload) != "jpeg" || p.Retain {
+		t.Errorf("snapshot = %q retain %t", p.Payload, p.Retain)
+	}
+
+	c.PublishMotion(true)
+	if p := broker.next(prefix + "motion"); string(p.Payload) != "ON" {
+		t.Errorf("motion = %q, want ON", p.Payload)
+	}
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"strings"
+	"time"
+)
+
+// CommandHandler performs actions requested by chat commands.
+type CommandHandler interface {
+	Armed() bool
+	// SetArmed overrides the arming mode until d has passed. A zero d
+	// never expires.
+	SetArmed(armed bool, d time.Duration)
+	Snapshot() ([]byte, error)
+}
+
+const commandHelp = "commands: /arm [duration] /disarm [duration] /status /snapshot"
+
+// runCommand executes a chat command. It returns a text reply and, for
+// /snapshot, a jpeg to send back.
+func runCommand(h CommandHandler, text string) (reply string, jpg []byte) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	// telegram allows /cmd@botname in group chats
+	cmd := strings.ToLower(strings.SplitN(fields[0], "@", 2)[0])
+
+	switch cmd {
+	case "/arm", "/disarm":
+		var d time.Duration
+		if len(fields) > 1 {
+			var err error
+			d, err = time.ParseDuration(fields[1])
+			if err != nil || d < 0 {
+				return "invalid duration " + fields[1] + ", e.g. " + cmd + " 2h", nil
+			}
+		}
+		armed := cmd == "/arm"
+		h.SetArmed(armed, d)
+		reply = "disarmed"
+		if armed {
+			reply = "armed"
+		}
+		if d > 0 {
+			reply += " for " + d.String()
+		}
+		return reply, nil
+	case "/status":
+		if h.Armed() {
+			return "armed", nil
+		}
+		return "disarmed", nil
+	case "/snapshot":
+		img, err := h.Snapshot()
+		if err != nil {
+			return "snapshot failed: " + err.Error(), nil
+		}
+		return "", img
+	case "/help", "/start":
+		return commandHelp, nil
+	}
+
+	if strings.HasPrefix(cmd, "/") {
+		return "unknown command, " + commandHelp, nil
+	}
+	return "", nil
+}
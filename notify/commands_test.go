@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type testCommandHandler struct {
+	armed bool
+	d     time.Duration
+}
+
+func (h *testCommandHandler) Armed() bool {
+	return h.armed
+}
+
+func (h *testCommandHandler) SetArmed(armed bool, d time.Duration) {
+	h.armed = armed
+	h.d = d
+}
+
+func (h *testCommandHandler) Snapshot() ([]byte, error) {
+	return nil, errors.New("no frames")
+}
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		text      string
+		reply     string
+		wantArmed bool
+		wantD     time.Duration
+	}{
+		{text: "/arm", reply: "armed", wantArmed: true},
+		{text: "/arm@cambot 2h", reply: "armed for 2h0m0s", wantArmed: true, wantD: 2 * time.Hour},
+		{text: "/disarm 30m", reply: "disarmed for 30m0s", wantD: 30 * time.Minute},
+		{text: "/disarm soon", reply: "invalid duration soon, e.g. /disarm 2h"},
+		{text: "/status", reply: "disarmed"},
+		{text: "/snapshot", reply: "snapshot failed: no frames"},
+		{text: "/nope", reply: "unknown command, " + commandHelp},
+		{text: "hello"},
+	}
+
+	for _, tt := range tests {
+		h := new(testCommandHandler)
+		reply, jpg := runCommand(h, tt.text)
+		if reply != tt.reply || jpg != nil {
+			t.Errorf("%q: reply = %q, %q, want %q", tt.text, reply, jpg, tt.reply)
+		}
+		if h.armed != tt.wantArmed || h.d != tt.wantD {
+			t.Errorf("%q: SetArmed(%t, %s), want (%t, %s)", tt.text, h.armed, h.d, tt.wantArmed, tt.wantD)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/psanford/rom-cam/config"
+)
+
+const (
+	EmailTLSStartTLS = "starttls"
+	EmailTLSImplicit = "tls"
+	EmailTLSNone     = "none"
+)
+
+// Email sends one message per event with the image attached inline.
+type Email struct {
+	conf config.Email
+}
+
+func NewEmail(conf config.Email) (*Email, error) {
+	if conf.Host == "" || conf.From == "" || len(conf.To) == 0 {
+		return nil, fmt.Errorf("email notifier requires host, from and to")
+	}
+	if conf.TLS == "" {
+		conf.TLS = EmailTLSStartTLS
+	}
+	switch conf.TLS {
+	case EmailTLSStartTLS, EmailTLSImplicit, EmailTLSNone:
+	default:
+		return nil, fmt.Errorf("unknown email tls mode %q", conf.TLS)
+	}
+	if conf.Port == 0 {
+		conf.Port = 587
+		if conf.TLS == EmailTLSImplicit {
+			conf.Port = 465
+		}
+	}
+	return &Email{
+		conf: conf,
+	}, nil
+}
+
+func (e *Email) Name() string {
+	return "email"
+}
+
+func (e *Email) Notify(ctx context.Context, n *Notification) error {
+	msg, err := e.message(n)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(e.conf.Host, strconv.Itoa(e.conf.Port))
+	tlsConf := &tls.Config{
+		ServerName:         e.conf.Host,
+		InsecureSkipVerify: e.conf.InsecureSkipVerify,
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	if e.conf.TLS == EmailTLSImplicit {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConf}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	}
+
+	c, err := smtp.NewClient(conn, e.conf.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.conf.TLS == EmailTLSStartTLS {
+		if err := c.StartTLS(tlsConf); err != nil {
+			return fmt.Errorf("starttls err: %w", err)
+		}
+	}
+
+	if e.conf.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.conf.Username, e.conf.Password, e.conf.Host)); err != nil {
+			return fmt.Errorf("smtp auth err: %w", err)
+		}
+	}
+
+	if err := c.Mail(e.conf.From); err != nil {
+		return err
+	}
+	for _, to := range e.conf.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+var emailBody = template.Must(template.New("email").Parse(`<html><body>
+<h3>{{.Title}}</h3>
+<p>Motion frames: {{.Frames}}</p>
+{{if .ClipURL}}<p><a href="{{.ClipURL}}">View clip</a></p>{{end}}
+{{if .HasImage}}<p><img src="cid:{{.ImageCID}}" alt="{{.Title}}"></p>{{end}}
+</body></html>
+`))
+
+func (e *Email) message(n *Notification) ([]byte, error) {
+	img := n.Image(e.conf.Image == "best_frame")
+
+	var cidBytes [12]byte
+	rand.Read(cidBytes[:])
+	imageCID := hex.EncodeToString(cidBytes[:]) + "@rom-cam"
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	hdr := func(k, v string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	hdr("From", e.conf.From)
+	hdr("To", strings.Join(e.conf.To, ", "))
+	hdr("Subject", mime.QEncoding.Encode("utf-8", n.Title))
+	hdr("Date", time.Now().Format(time.RFC1123Z))
+	hdr("MIME-Version", "1.0")
+	hdr("Content-Type", fmt.Sprintf(`multipart/related; boundary="%s"`, mw.Boundary()))
+	buf.WriteString("\r\n")
+
+	htmlPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/html; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = emailBody.Execute(htmlPart, struct {
+		*Notification
+		HasImage bool
+		ImageCID string
+	}{n, img != nil, imageCID})
+	if err != nil {
+		return nil, err
+	}
+
+	if img != nil {
+		imgPart, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/jpeg"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-ID":                {"<" + imageCID + ">"},
+			"Content-Disposition":       {fmt.Sprintf(`inline; filename="%s.jpg"`, n.TS.Format("20060102-150405"))},
+		})
+		if err != nil {
+			return nil, err
+		}
+		enc := base64.StdEncoding.EncodeToString(img)
+		for len(enc) > 76 {
+			fmt.Fprintf(imgPart, "%s\r\n", enc[:76])
+			enc = enc[76:]
+		}
+		fmt.Fprintf(imgPart, "%s\r\n", enc)
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,316 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psanford/rom-cam/config"
+)
+
+// smtpServer is just enough of an SMTP server to receive one message.
+type smtpServer struct {
+	t  *testing.T
+	ln net.Listener
+	// tlsConf enables STARTTLS, or implicit TLS if implicit is set.
+	tlsConf  *tls.Config
+	implicit bool
+
+	got chan smtpMessage
+}
+
+type smtpMessage struct {
+	tls  bool
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+func newSMTPServer(t *testing.T, tlsMode string) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{
+		t:   t,
+		ln:  ln,
+		got: make(chan smtpMessage, 1),
+	}
+	if tlsMode != EmailTLSNone {
+		s.tlsConf = &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}
+		s.implicit = tlsMode == EmailTLSImplicit
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	nc, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer nc.Close()
+	nc.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var msg smtpMessage
+	if s.implicit {
+		nc = tls.Server(nc, s.tlsConf)
+		msg.tls = true
+	}
+	tp := textproto.NewConn(nc)
+	reply := func(format string, args ...interface{}) {
+		if err := tp.PrintfLine(format, args...); err != nil {
+			s.t.Error(err)
+		}
+	}
+
+	reply("220 localhost ESMTP test")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			s.t.Errorf("smtp read: %s", err)
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			exts := []string{"localhost"}
+			if s.tlsConf != nil && !msg.tls {
+				exts = append(exts, "STARTTLS")
+			}
+			if msg.tls {
+				exts = append(exts, "AUTH PLAIN")
+			}
+			for i, ext := range exts {
+				sep := "-"
+				if i == len(exts)-1 {
+					sep = " "
+				}
+				reply("250%s%s", sep, ext)
+			}
+		case "STARTTLS":
+			reply("220 go ahead")
+			nc = tls.Server(nc, s.tlsConf)
+			tp = textproto.NewConn(nc)
+			msg.tls = true
+		case "AUTH":
+			_, resp, _ := strings.Cut(arg, " ")
+			dec, _ := base64.StdEncoding.DecodeString(resp)
+			msg.auth = string(dec)
+			reply("235 ok")
+		case "MAIL":
+			msg.from = arg
+			reply("250 ok")
+		case "RCPT":
+			msg.to = append(msg.to, arg)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			msg.data, err = tp.ReadDotBytes()
+			if err != nil {
+				s.t.Errorf("smtp read data: %s", err)
+				return
+			}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			s.got <- msg
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		mode     string
+		username string
+		wantTLS  bool
+	}{
+		{mode: EmailTLSNone},
+		{mode: EmailTLSStartTLS, username: "cam", wantTLS: true},
+		{mode: EmailTLSImplicit, username: "cam", wantTLS: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			srv := newSMTPServer(t, tt.mode)
+
+			e, err := NewEmail(config.Email{
+				Host:               "127.0.0.1",
+				Port:               srv.port(),
+				Username:           tt.username,
+				Password:           "hunter2",
+				From:               "cam@example.com",
+				To:                 []string{"a@example.com", "b@example.com"},
+				TLS:                tt.mode,
+				InsecureSkipVerify: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n := testNotification()
+			n.Title = "Front Door é"
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := e.Notify(ctx, n); err != nil {
+				t.Fatal(err)
+			}
+
+			got := <-srv.got
+			if got.tls != tt.wantTLS {
+				t.Errorf("tls = %t, want %t", got.tls, tt.wantTLS)
+			}
+			if tt.username != "" && got.auth != "\x00cam\x00hunter2" {
+				t.Errorf("auth = %q", got.auth)
+			}
+			if got.from != "FROM:<cam@example.com>" {
+				t.Errorf("MAIL %s", got.from)
+			}
+			if strings.Join(got.to, " ") != "TO:<a@example.com> TO:<b@example.com>" {
+				t.Errorf("RCPT %q", got.to)
+			}
+			checkEmailMessage(t, got.data, n)
+		})
+	}
+}
+
+// checkEmailMessage checks the message is multipart/related with the
+// html body referencing the inline image by its Content-ID.
+func checkEmailMessage(t *testing.T, data []byte, n *Notification) {
+	t.Helper()
+
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := new(mime.WordDecoder)
+	if subject, err := dec.DecodeHeader(m.Header.Get("Subject")); err != nil || subject != n.Title {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" {
+		t.Fatalf("content type = %q, %v", m.Header.Get("Content-Type"), err)
+	}
+
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	htmlPart, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := htmlPart.Header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("first part content type = %q", ct)
+	}
+	html, _ := io.ReadAll(htmlPart)
+
+	imgPart, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := imgPart.Header.Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("image content type = %q", ct)
+	}
+	if disp := imgPart.Header.Get("Content-Disposition"); !strings.HasPrefix(disp, "inline;") {
+		t.Errorf("image disposition = %q", disp)
+	}
+	cid := strings.Trim(imgPart.Header.Get("Content-ID"), "<>")
+	if cid == "" {
+		t.Fatal("image has no Content-ID")
+	}
+	if !bytes.Contains(html, []byte(`src="cid:`+cid+`"`)) {
+		t.Errorf("html doesn't reference cid %s:\n%s", cid, html)
+	}
+	if !bytes.Contains(html, []byte(`href="`+n.ClipURL+`"`)) {
+		t.Errorf("html has no clip link:\n%s", html)
+	}
+
+	enc, _ := io.ReadAll(imgPart)
+	img, err := base64.StdEncoding.DecodeString(strings.NewReplacer("\r", "", "\n", "").Replace(string(enc)))
+	if err != nil || !bytes.Equal(img, n.TiledJPG) {
+		t.Errorf("image = %q, %v, want %q", img, err, n.TiledJPG)
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("extra part after the image: %v", err)
+	}
+}
+
+func TestEmailConfig(t *testing.T) {
+	base := config.Email{Host: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}}
+
+	tests := []struct {
+		tls  string
+		port int
+	}{
+		{"", 587},
+		{EmailTLSStartTLS, 587},
+		{EmailTLSImplicit, 465},
+		{EmailTLSNone, 587},
+	}
+	for _, tt := range tests {
+		conf := base
+		conf.TLS = tt.tls
+		e, err := NewEmail(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.conf.Port != tt.port {
+			t.Errorf("tls %q: port = %d, want %d", tt.tls, e.conf.Port, tt.port)
+		}
+	}
+
+	conf := base
+	conf.TLS = "ssl"
+	if _, err := NewEmail(conf); err == nil {
+		t.Error("unknown tls mode accepted")
+	}
+	if _, err := NewEmail(config.Email{Host: "smtp.example.com"}); err == nil {
+		t.Error("missing from and to accepted")
+	}
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/psanford/rom-cam/config"
+)
+
+// Gotify posts a message to a gotify server. Gotify has no attachment
+// support so the snapshot is linked by url.
+type Gotify struct {
+	conf   config.Gotify
+	client *http.Client
+}
+
+func NewGotify(conf config.Gotify) (*Gotify, error) {
+	if conf.Server == "" || conf.Token == "" {
+		return nil, fmt.Errorf("gotify notifier requires server and token")
+	}
+	return &Gotify{
+		conf:   conf,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (g *Gotify) Name() string {
+	return "gotify"
+}
+
+type gotifyMessage struct {
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Priority int                    `json:"priority"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+}
+
+func (g *Gotify) Notify(ctx context.Context, n *Notification) error {
+	message := fmt.Sprintf("%d motion frames", n.Frames)
+	if n.ImageURL != "" {
+		message += fmt.Sprintf("\n\n![snapshot](%s)", n.ImageURL)
+	}
+	if n.ClipURL != "" {
+		message += fmt.Sprintf("\n\n[View clip](%s)", n.ClipURL)
+	}
+
+	extras := map[string]interface{}{
+		"client::display": map[string]string{
+			"contentType": "text/markdown",
+		},
+	}
+	notification := map[string]interface{}{}
+	if n.ClipURL != "" {
+		notification["click"] = map[string]string{"url": n.ClipURL}
+	}
+	if n.ImageURL != "" {
+		notification["bigImageUrl"] = n.ImageURL
+	}
+	if len(notification) > 0 {
+		extras["client::notification"] = notification
+	}
+
+	body, err := json.Marshal(gotifyMessage{
+		Title:   n.Title,
+		Message: message,
+		// gotify priorities run 0-10
+		Priority: motionPriority(n) * 2,
+		Extras:   extras,
+	})
+	if err != nil {
+		return err
+	}
+
+	u := strings.TrimSuffix(g.conf.Server, "/") + "/message"
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// a header rather than ?token= keeps the token out of access logs
+	req.Header.Set("X-Gotify-Key", g.conf.Token)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<12))
+		return fmt.Errorf("gotify status %d: %s", resp.StatusCode, msg)
+	}
+
+	return nil
+}
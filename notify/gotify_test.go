@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/psanford/rom-cam/config"
+)
+
+func TestGotify(t *testing.T) {
+	var (
+		gotKey string
+		gotURL string
+		msg    gotifyMessage
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("X-Gotify-Key")
+		gotURL = r.URL.String()
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	g, err := NewGotify(config.Gotify{Server: srv.URL + "/", Token: "app-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotKey != "app-token" {
+		t.Errorf("X-Gotify-Key = %q, want app-token", gotKey)
+	}
+	// the token stays out of the url, and so out of access logs
+	if gotURL != "/message" {
+		t.Errorf("url = %s, want /message", gotURL)
+	}
+	if msg.Title != `Front Door "porch"` || msg.Priority < 0 || msg.Priority > 10 {
+		t.Errorf("message = %+v", msg)
+	}
+}
@@ -0,0 +1,239 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/config"
+)
+
+// Matrix posts events to a room using the matrix client-server api.
+type Matrix struct {
+	conf   config.Matrix
+	client *http.Client
+	lgr    log15.Logger
+	txnID  int64
+}
+
+func NewMatrix(lgr log15.Logger, conf config.Matrix) (*Matrix, error) {
+	if conf.Homeserver == "" || conf.AccessToken == "" || conf.RoomID == "" {
+		return nil, fmt.Errorf("matrix notifier requires homeserver, access_token and room_id")
+	}
+	if conf.Commands && len(conf.AllowedSenders) == 0 {
+		return nil, fmt.Errorf("matrix commands require allowed_senders")
+	}
+	return &Matrix{
+		conf:   conf,
+		client: &http.Client{Timeout: 2 * time.Minute},
+		lgr:    lgr,
+		txnID:  time.Now().UnixNano(),
+	}, nil
+}
+
+func (m *Matrix) Name() string {
+	return "matrix"
+}
+
+func (m *Matrix) Notify(ctx context.Context, n *Notification) error {
+	text := n.Title
+	if n.ClipURL != "" {
+		text += "\n" + n.ClipURL
+	}
+	err := m.sendMessage(ctx, map[string]interface{}{
+		"msgtype": "m.text",
+		"body":    text,
+	})
+	if err != nil {
+		return err
+	}
+
+	name := n.TS.Format("20060102-150405")
+
+	if img := n.Image(m.conf.Image != "tiled"); img != nil {
+		err = m.sendMedia(ctx, "m.image", name+".jpg", "image/jpeg", img)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.MP4 != nil {
+		err = m.sendMedia(ctx, "m.video", name+".mp4", "video/mp4", n.MP4)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Matrix) sendMedia(ctx context.Context, msgtype, filename, contentType string, data []byte) error {
+	var upload struct {
+		ContentURI string `json:"content_uri"`
+	}
+	u := m.url("/_matrix/media/v3/upload") + "?filename=" + url.QueryEscape(filename)
+	err := m.do(ctx, "POST", u, contentType, bytes.NewReader(data), &upload)
+	if err != nil {
+		return fmt.Errorf("matrix upload err: %w", err)
+	}
+
+	return m.sendMessage(ctx, map[string]interface{}{
+		"msgtype": msgtype,
+		"body":    filename,
+		"url":     upload.ContentURI,
+		"info": map[string]interface{}{
+			"mimetype": contentType,
+			"size":     len(data),
+		},
+	})
+}
+
+func (m *Matrix) sendMessage(ctx context.Context, content map[string]interface{}) error {
+	body, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	txn := atomic.AddInt64(&m.txnID, 1)
+	u := m.url(fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%d", url.PathEscape(m.conf.RoomID), txn))
+	return m.do(ctx, "PUT", u, "application/json", bytes.NewReader(body), nil)
+}
+
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []struct {
+					Type    string `json:"type"`
+					Sender  string `json:"sender"`
+					Content struct {
+						MsgType string `json:"msgtype"`
+						Body    string `json:"body"`
+					} `json:"content"`
+				} `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+// Listen syncs with the homeserver and runs commands posted to the room
+// until ctx is done. Messages sent before Listen started are ignored.
+func (m *Matrix) Listen(ctx context.Context, h CommandHandler) {
+	var self struct {
+		UserID string `json:"user_id"`
+	}
+	for {
+		err := m.do(ctx, "GET", m.url("/_matrix/client/v3/account/whoami"), "", nil, &self)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			break
+		}
+		m.lgr.Error("matrix_whoami_err", "err", err)
+		time.Sleep(30 * time.Second)
+	}
+
+	filter := fmt.Sprintf(`{"room":{"rooms":[%q],"timeline":{"types":["m.room.message"]}},"presence":{"types":[]},"account_data":{"types":[]}}`, m.conf.RoomID)
+
+	var since string
+	for {
+		v := url.Values{"filter": {filter}}
+		if since != "" {
+			v.Set("since", since)
+			v.Set("timeout", "50000")
+		}
+
+		var resp matrixSync
+		err := m.do(ctx, "GET", m.url("/_matrix/client/v3/sync")+"?"+v.Encode(), "", nil, &resp)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			m.lgr.Error("matrix_sync_err", "err", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		initial := since == ""
+		since = resp.NextBatch
+		if initial {
+			continue
+		}
+
+		room, ok := resp.Rooms.Join[m.conf.RoomID]
+		if !ok {
+			continue
+		}
+		for _, ev := range room.Timeline.Events {
+			if ev.Type != "m.room.message" || ev.Sender == self.UserID || ev.Content.MsgType != "m.text" {
+				continue
+			}
+			if !m.allowed(ev.Sender) {
+				m.lgr.Info("matrix_command_rejected", "sender", ev.Sender)
+				continue
+			}
+
+			reply, img := runCommand(h, ev.Content.Body)
+			if img != nil {
+				err = m.sendMedia(ctx, "m.image", "snapshot.jpg", "image/jpeg", img)
+			} else if reply != "" {
+				err = m.sendMessage(ctx, map[string]interface{}{
+					"msgtype": "m.notice",
+					"body":    reply,
+				})
+			}
+			if err != nil {
+				m.lgr.Error("matrix_reply_err", "err", err)
+			}
+		}
+	}
+}
+
+func (m *Matrix) allowed(sender string) bool {
+	for _, s := range m.conf.AllowedSenders {
+		if s == sender {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matrix) url(path string) string {
+	return strings.TrimSuffix(m.conf.Homeserver, "/") + path
+}
+
+func (m *Matrix) do(ctx context.Context, method, u, contentType string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.conf.AccessToken)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<12))
+		return fmt.Errorf("matrix status %d: %s", resp.StatusCode, msg)
+	}
+
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
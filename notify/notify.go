@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/psanford/rom-cam/event"
+)
+
+// Notifier delivers motion events to an external service.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Updater is implemented by notifiers that can edit or follow up on a
+// message they already sent for the same EventID.
+type Updater interface {
+	Update(ctx context.Context, n *Notification) error
+}
+
+// Notification is a motion event along with everything a notifier might
+// want to show for it.
+type Notification struct {
+	event.Event
+
+	// Title is a human readable summary, e.g. "Front Door 2023-01-02T15:04:05-08:00".
+	Title string `json:"title"`
+
+	// EventID is shared by all notifications for consecutive motion
+	// segments that were aggregated into a single event.
+	EventID string `json:"event_id"`
+	// Segments is the number of motion segments in the event so far.
+	Segments int `json:"segments"`
+	// Update is set for follow up notifications for an EventID that has
+	// already been sent.
+	Update bool `json:"update,omitempty"`
+	// Digest is the number of events summarized by a daily digest
+	// notification. It is zero for regular notifications.
+	Digest int `json:"digest,omitempty"`
+
+	// ClipURL and ImageURL are links to the uploaded mp4 and tiled image.
+	// They are empty if the footage was not uploaded.
+	ClipURL  string `json:"clip_url,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+
+	// TiledJPG is a grid of frames from the segment and BestFrameJPG is the
+	// frame with the most motion. Either may be nil if generating it failed.
+	TiledJPG     []byte `json:"-"`
+	BestFrameJPG []byte `json:"-"`
+	// MP4 is the segment remuxed to mp4, or nil if that failed.
+	MP4 []byte `json:"-"`
+}
+
+// Image returns the preferred image for the notification, falling back
+// to whichever one is available.
+func (n *Notification) Image(preferBestFrame bool) []byte {
+	if preferBestFrame && n.BestFrameJPG != nil || n.TiledJPG == nil {
+		return n.BestFrameJPG
+	}
+	return n.TiledJPG
+}
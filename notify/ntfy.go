@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/psanford/rom-cam/config"
+)
+
+// Ntfy publishes to an ntfy topic with the snapshot as an attachment.
+type Ntfy struct {
+	conf   config.Ntfy
+	client *http.Client
+}
+
+func NewNtfy(conf config.Ntfy) (*Ntfy, error) {
+	if conf.Topic == "" {
+		return nil, fmt.Errorf("ntfy notifier requires a topic")
+	}
+	if conf.Server == "" {
+		conf.Server = "https://ntfy.sh"
+	}
+	return &Ntfy{
+		conf:   conf,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func (n *Ntfy) Name() string {
+	return "ntfy"
+}
+
+func (n *Ntfy) Notify(ctx context.Context, note *Notification) error {
+	url := strings.TrimSuffix(n.conf.Server, "/") + "/" + n.conf.Topic
+
+	var body io.Reader
+	img := note.Image(n.conf.Image == "best_frame")
+	message := fmt.Sprintf("%d motion frames", note.Frames)
+	if img != nil {
+		body = bytes.NewReader(img)
+	} else {
+		body = strings.NewReader(message)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, body)
+	if err != nil {
+		return err
+	}
+
+	// ntfy decodes rfc 2047 encoded headers
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", note.Title))
+	req.Header.Set("Priority", strconv.Itoa(motionPriority(note)))
+	req.Header.Set("Tags", "rotating_light")
+	if img != nil {
+		req.Header.Set("Filename", note.TS.Format("20060102-150405")+".jpg")
+		req.Header.Set("Message", mime.QEncoding.Encode("utf-8", message))
+	}
+	if note.ClipURL != "" {
+		req.Header.Set("Click", note.ClipURL)
+	}
+
+	if n.conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.conf.Token)
+	} else if n.conf.Username != "" {
+		req.SetBasicAuth(n.conf.Username, n.conf.Password)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<12))
+		return fmt.Errorf("ntfy status %d: %s", resp.StatusCode, msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,16 @@
+package notify
+
+// motionPriority maps how much of the segment had motion onto a 1 (min)
+// to 5 (max) priority scale, as used by ntfy.
+func motionPriority(n *Notification) int {
+	switch {
+	case n.Frames >= 50:
+		return 5
+	case n.Frames >= 20:
+		return 4
+	case n.Frames >= 5:
+		return 3
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/metrics"
+)
+
+// Queue hands notifications to another notifier on its own goroutine so a
+// slow endpoint doesn't hold up the caller. When the queue is full new
+// notifications are dropped.
+type Queue struct {
+	inner Notifier
+	lgr   log15.Logger
+	ch    chan *Notification
+}
+
+func NewQueue(lgr log15.Logger, inner Notifier, size int) *Queue {
+	return &Queue{
+		inner: inner,
+		lgr:   lgr,
+		ch:    make(chan *Notification, size),
+	}
+}
+
+func (q *Queue) Name() string {
+	return q.inner.Name()
+}
+
+// Notify queues n without blocking. n must not be modified afterwards.
+func (q *Queue) Notify(ctx context.Context, n *Notification) error {
+	select {
+	case q.ch <- n:
+	default:
+		q.lgr.Error("notify_dropped", "notifier", q.Name(), "reason", "queue_full", "event_id", n.EventID)
+		metrics.Notifications.WithLabelValues(q.Name(), metrics.ResultDropped).Inc()
+	}
+	return nil
+}
+
+// Run sends queued notifications until ctx is done.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-q.ch:
+			if err := q.inner.Notify(ctx, n); err != nil {
+				q.lgr.Error("notify_err", "notifier", q.Name(), "err", err)
+			}
+		}
+	}
+}
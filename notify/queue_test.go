@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/psanford/rom-cam/metrics"
+)
+
+// blockingNotifier waits for release before each notification returns.
+type blockingNotifier struct {
+	started chan string
+	release chan struct{}
+}
+
+func (b *blockingNotifier) Name() string {
+	return "queue_test_blocking"
+}
+
+func (b *blockingNotifier) Notify(ctx context.Context, n *Notification) error {
+	b.started <- n.EventID
+	<-b.release
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	inner := &blockingNotifier{
+		started: make(chan string, 10),
+		release: make(chan struct{}),
+	}
+	q := NewQueue(discardLogger(), inner, 2)
+	dropped := notificationCount(t, inner.Name(), metrics.ResultDropped)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	notify := func(id string) {
+		n := testNotification()
+		n.EventID = id
+		done := make(chan struct{})
+		go func() {
+			q.Notify(ctx, n)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Notify(%s) blocked", id)
+		}
+	}
+
+	// a is in flight, b and c fill the queue and d is dropped
+	notify("a")
+	if got := <-inner.started; got != "a" {
+		t.Fatalf("started %s, want a", got)
+	}
+	for _, id := range []string{"b", "c", "d"} {
+		notify(id)
+	}
+	if got := dropped(); got != 1 {
+		t.Errorf("dropped = %v, want 1", got)
+	}
+
+	for _, want := range []string{"b", "c"} {
+		inner.release <- struct{}{}
+		if got := <-inner.started; got != want {
+			t.Errorf("started %s, want %s", got, want)
+		}
+	}
+	inner.release <- struct{}{}
+}
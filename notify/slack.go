@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/slack-go/slack"
+)
+
+// Slack posts an attachment with a link to the clip to a slack incoming webhook.
+type Slack struct {
+	webhookURL string
+}
+
+func NewSlack(webhookURL string) *Slack {
+	return &Slack{
+		webhookURL: webhookURL,
+	}
+}
+
+func (s *Slack) Name() string {
+	return "slack"
+}
+
+func (s *Slack) Notify(ctx context.Context, n *Notification) error {
+	return slack.PostWebhookContext(ctx, s.webhookURL, &slack.WebhookMessage{
+		Attachments: []slack.Attachment{
+			{
+				Title:     n.Title,
+				TitleLink: n.ClipURL,
+				ImageURL:  n.ImageURL,
+				Fields: []slack.AttachmentField{
+					{
+						Title: "Frames",
+						Value: strconv.Itoa(n.Frames),
+						Short: true,
+					},
+				},
+			},
+		},
+	})
+}
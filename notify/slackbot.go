@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// SlackBot uses the slack web api to post events with the tiled image and
+// mp4 uploaded directly to the channel. Follow up segments for the same
+// event are posted as thread replies.
+type SlackBot struct {
+	client  *slack.Client
+	channel string
+
+	mu      sync.Mutex
+	threads map[string]slackThread
+}
+
+type slackThread struct {
+	channel string
+	ts      string
+	// title is the original message's title, which updates keep.
+	title   string
+	created time.Time
+}
+
+// slackThreadTTL is how long we remember the thread for an event.
+const slackThreadTTL = 24 * time.Hour
+
+func NewSlackBot(token, channel string) *SlackBot {
+	return &SlackBot{
+		client:  slack.New(token),
+		channel: channel,
+		threads: make(map[string]slackThread),
+	}
+}
+
+func (s *SlackBot) Name() string {
+	return "slack_bot"
+}
+
+func (s *SlackBot) Notify(ctx context.Context, n *Notification) error {
+	channel, ts, err := s.client.PostMessageContext(ctx, s.channel,
+		slack.MsgOptionText(n.Title, false),
+		slack.MsgOptionAttachments(s.attachment(n)),
+	)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	for id, t := range s.threads {
+		if now.Sub(t.created) > slackThreadTTL {
+			delete(s.threads, id)
+		}
+	}
+	s.threads[n.EventID] = slackThread{
+		channel: channel,
+		ts:      ts,
+		title:   n.Title,
+		created: now,
+	}
+	s.mu.Unlock()
+
+	return s.uploadMedia(ctx, n, channel, ts)
+}
+
+// Update refreshes the segment count on the original message, keeping its
+// title, and adds the new segment's media to its thread.
+func (s *SlackBot) Update(ctx context.Context, n *Notification) error {
+	s.mu.Lock()
+	thread, ok := s.threads[n.EventID]
+	s.mu.Unlock()
+
+	if !ok {
+		return s.Notify(ctx, n)
+	}
+
+	_, _, _, err := s.client.UpdateMessageContext(ctx, thread.channel, thread.ts,
+		slack.MsgOptionText(thread.title, false),
+		slack.MsgOptionAttachments(s.attachment(n)),
+	)
+	if err != nil {
+		return err
+	}
+
+	return s.uploadMedia(ctx, n, thread.channel, thread.ts)
+}
+
+func (s *SlackBot) attachment(n *Notification) slack.Attachment {
+	return slack.Attachment{
+		Fields: []slack.AttachmentField{
+			{
+				Title: "Frames",
+				Value: fmt.Sprint(n.Frames),
+				Short: true,
+			},
+			{
+				Title: "Segments",
+				Value: fmt.Sprint(n.Segments),
+				Short: true,
+			},
+		},
+	}
+}
+
+// uploadMedia uploads the event's media to the thread. channel must be a
+// channel id, as returned by PostMessage, rather than a name.
+func (s *SlackBot) uploadMedia(ctx context.Context, n *Notification, channel, threadTS string) error {
+	name := n.TS.Format("20060102-150405")
+
+	if n.TiledJPG != nil {
+		_, err := s.client.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
+			Reader:          bytes.NewReader(n.TiledJPG),
+			FileSize:        len(n.TiledJPG),
+			Filename:        name + ".jpg",
+			Title:           n.Title,
+			Channel:         channel,
+			ThreadTimestamp: threadTS,
+		})
+		if err != nil {
+			return fmt.Errorf("upload tiled image err: %w", err)
+		}
+	}
+
+	if n.MP4 != nil {
+		_, err := s.client.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
+			Reader:          bytes.NewReader(n.MP4),
+			FileSize:        len(n.MP4),
+			Filename:        name + ".mp4",
+			Title:           n.Title,
+			Channel:         channel,
+			ThreadTimestamp: threadTS,
+		})
+		if err != nil {
+			return fmt.Errorf("upload mp4 err: %w", err)
+		}
+	}
+
+	return nil
+}
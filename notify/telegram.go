@@ -0,0 +1,235 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/config"
+)
+
+// Telegram posts events to a chat using the telegram bot api.
+type Telegram struct {
+	conf   config.Telegram
+	client *http.Client
+	lgr    log15.Logger
+}
+
+func NewTelegram(lgr log15.Logger, conf config.Telegram) (*Telegram, error) {
+	if conf.Token == "" || conf.ChatID == "" {
+		return nil, fmt.Errorf("telegram notifier requires token and chat_id")
+	}
+	if conf.Commands && len(conf.AllowedUsers) == 0 {
+		return nil, fmt.Errorf("telegram commands require allowed_users")
+	}
+	if conf.APIURL == "" {
+		conf.APIURL = "https://api.telegram.org"
+	}
+	return &Telegram{
+		conf:   conf,
+		client: &http.Client{Timeout: 2 * time.Minute},
+		lgr:    lgr,
+	}, nil
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+func (t *Telegram) Notify(ctx context.Context, n *Notification) error {
+	caption := n.Title
+	if n.ClipURL != "" {
+		caption += "\n" + n.ClipURL
+	}
+
+	if img := n.Image(t.conf.Image != "tiled"); img != nil {
+		err := t.sendFile(ctx, t.conf.ChatID, "sendPhoto", "photo", n.TS.Format("20060102-150405")+".jpg", img, caption)
+		if err != nil {
+			return err
+		}
+		caption = ""
+	}
+
+	if n.MP4 != nil {
+		return t.sendFile(ctx, t.conf.ChatID, "sendVideo", "video", n.TS.Format("20060102-150405")+".mp4", n.MP4, caption)
+	}
+
+	if caption != "" {
+		return t.sendMessage(ctx, t.conf.ChatID, caption)
+	}
+
+	return nil
+}
+
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		// From is unset for messages sent on behalf of a channel.
+		From *struct {
+			ID int64 `json:"id"`
+		} `json:"from"`
+		Chat struct {
+			ID       int64  `json:"id"`
+			Username string `json:"username"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// Listen long polls for bot commands from the configured chat until ctx
+// is done. Messages from other chats or from users not in AllowedUsers
+// are ignored.
+func (t *Telegram) Listen(ctx context.Context, h CommandHandler) {
+	var offset int64
+	for {
+		updates, err := t.getUpdates(ctx, offset)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			t.lgr.Error("telegram_get_updates_err", "err", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message == nil {
+				continue
+			}
+			chatID := strconv.FormatInt(u.Message.Chat.ID, 10)
+			if chatID != t.conf.ChatID && "@"+u.Message.Chat.Username != t.conf.ChatID {
+				t.lgr.Info("telegram_ignore_chat", "chat_id", chatID)
+				continue
+			}
+			if u.Message.From == nil || !t.allowed(u.Message.From.ID) {
+				var from int64
+				if u.Message.From != nil {
+					from = u.Message.From.ID
+				}
+				t.lgr.Info("telegram_command_rejected", "chat_id", chatID, "from", from)
+				continue
+			}
+
+			reply, img := runCommand(h, u.Message.Text)
+			if img != nil {
+				err = t.sendFile(ctx, chatID, "sendPhoto", "photo", "snapshot.jpg", img, "")
+			} else if reply != "" {
+				err = t.sendMessage(ctx, chatID, reply)
+			}
+			if err != nil {
+				t.lgr.Error("telegram_reply_err", "err", err)
+			}
+		}
+	}
+}
+
+func (t *Telegram) allowed(userID int64) bool {
+	for _, id := range t.conf.AllowedUsers {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Telegram) getUpdates(ctx context.Context, offset int64) ([]telegramUpdate, error) {
+	v := url.Values{
+		"timeout":         {"50"},
+		"offset":          {strconv.FormatInt(offset, 10)},
+		"allowed_updates": {`["message"]`},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.methodURL("getUpdates"), strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var updates []telegramUpdate
+	err = t.do(req, &updates)
+	return updates, err
+}
+
+func (t *Telegram) sendMessage(ctx context.Context, chatID, text string) error {
+	v := url.Values{
+		"chat_id": {chatID},
+		"text":    {text},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.methodURL("sendMessage"), strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return t.do(req, nil)
+}
+
+func (t *Telegram) sendFile(ctx context.Context, chatID, method, field, filename string, data []byte, caption string) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("chat_id", chatID)
+	if caption != "" {
+		mw.WriteField("caption", caption)
+	}
+	fw, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		return err
+	}
+	fw.Write(data)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", t.methodURL(method), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return t.do(req, nil)
+}
+
+func (t *Telegram) methodURL(method string) string {
+	return fmt.Sprintf("%s/bot%s/%s", strings.TrimSuffix(t.conf.APIURL, "/"), t.conf.Token, method)
+}
+
+func (t *Telegram) do(req *http.Request, result interface{}) error {
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// don't leak the bot token embedded in the url
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var tr telegramResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr)
+	if err != nil {
+		return fmt.Errorf("telegram status %d: decode response err: %w", resp.StatusCode, err)
+	}
+	if !tr.OK {
+		return fmt.Errorf("telegram status %d: %s", resp.StatusCode, tr.Description)
+	}
+
+	if result != nil {
+		return json.Unmarshal(tr.Result, result)
+	}
+	return nil
+}
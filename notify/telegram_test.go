@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/psanford/rom-cam/config"
+)
+
+func TestTelegramConfig(t *testing.T) {
+	conf := config.Telegram{Token: "123:abc", ChatID: "-100", Commands: true}
+	if _, err := NewTelegram(discardLogger(), conf); err == nil {
+		t.Error("commands accepted without allowed_users")
+	}
+	conf.AllowedUsers = []int64{42}
+	if _, err := NewTelegram(discardLogger(), conf); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTelegramListen(t *testing.T) {
+	// a group chat where user 42 is allowed and user 7 is not
+	updates := []string{
+		`{"update_id": 1, "message": {"text": "/disarm", "from": {"id": 7}, "chat": {"id": -100}}}`,
+		`{"update_id": 2, "message": {"text": "/disarm", "chat": {"id": -100}}}`,
+		`{"update_id": 3, "message": {"text": "/arm", "from": {"id": 42}, "chat": {"id": -200}}}`,
+		`{"update_id": 4, "message": {"text": "/arm 1h", "from": {"id": 42}, "chat": {"id": -100}}}`,
+	}
+
+	replies := make(chan string, len(updates))
+	polled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bot123:abc/getUpdates":
+			if polled {
+				<-r.Context().Done()
+				return
+			}
+			polled = true
+			fmt.Fprintf(w, `{"ok": true, "result": [%s, %s, %s, %s]}`, updates[0], updates[1], updates[2], updates[3])
+		case "/bot123:abc/sendMessage":
+			replies <- r.FormValue("chat_id") + " " + r.FormValue("text")
+			json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	tg, err := NewTelegram(discardLogger(), config.Telegram{
+		Token:        "123:abc",
+		ChatID:       "-100",
+		Commands:     true,
+		AllowedUsers: []int64{42},
+		APIURL:       srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := new(testCommandHandler)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tg.Listen(ctx, h)
+		close(done)
+	}()
+
+	select {
+	case reply := <-replies:
+		if reply != "-100 armed for 1h0m0s" {
+			t.Errorf("reply = %q", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to the allowed user")
+	}
+	cancel()
+	<-done
+
+	if len(replies) != 0 {
+		t.Errorf("replied to rejected messages: %q", <-replies)
+	}
+	if !h.armed || h.d != time.Hour {
+		t.Errorf("SetArmed(%t, %s), want (true, 1h)", h.armed, h.d)
+	}
+}
@@ -0,0 +1,205 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/metrics"
+)
+
+// Throttle sits in front of another notifier and applies cooldown,
+// aggregation, quiet hours and digest rules per camera.
+type Throttle struct {
+	inner Notifier
+	conf  config.Notify
+	loc   *time.Location
+	lgr   log15.Logger
+
+	mu     sync.Mutex
+	state  map[string]*throttleState
+	digest map[string]*digestSummary
+}
+
+type throttleState struct {
+	eventID    string
+	lastMotion time.Time
+	segments   int
+	sent       bool
+	lastSent   time.Time
+}
+
+// digestSummary is what the digest needs from a camera's events since the
+// last one was sent. It is a summary rather than a queue of notifications
+// so a busy day doesn't hold every event's media in memory.
+type digestSummary struct {
+	count int
+	first time.Time
+	// best is the event with the most motion, with a single preview image
+	// and no mp4.
+	best *Notification
+}
+
+func NewThrottle(lgr log15.Logger, inner Notifier, conf config.Notify, loc *time.Location) *Throttle {
+	return &Throttle{
+		inner:  inner,
+		conf:   conf,
+		loc:    loc,
+		lgr:    lgr,
+		state:  make(map[string]*throttleState),
+		digest: make(map[string]*digestSummary),
+	}
+}
+
+func (t *Throttle) Name() string {
+	return t.inner.Name()
+}
+
+func (t *Throttle) Notify(ctx context.Context, n *Notification) error {
+	t.mu.Lock()
+
+	st := t.state[n.Camera]
+	if st == nil {
+		st = &throttleState{}
+		t.state[n.Camera] = st
+	}
+
+	if st.eventID != "" && t.conf.AggregateWindow > 0 && n.TS.Sub(st.lastMotion) <= t.conf.AggregateWindow {
+		st.lastMotion = n.TS
+		st.segments++
+
+		out := *n
+		out.EventID = st.eventID
+		out.Segments = st.segments
+		out.Update = true
+		sent := st.sent
+		t.mu.Unlock()
+
+		// follow-ups only edit a notification that was sent, and only
+		// for notifiers that can edit one
+		updater, ok := t.inner.(Updater)
+		if !sent || !ok {
+			t.lgr.Info("notify_suppressed", "notifier", t.Name(), "reason", "aggregated", "event_id", out.EventID, "segments", out.Segments)
+			t.count(metrics.ResultSuppressed, nil)
+			return nil
+		}
+		err := updater.Update(ctx, &out)
+		t.count(metrics.ResultUpdated, err)
+		return err
+	}
+
+	*st = throttleState{
+		eventID:    n.EventID,
+		lastMotion: n.TS,
+		segments:   1,
+		lastSent:   st.lastSent,
+	}
+
+	if t.conf.DigestAt != "" {
+		d := t.digest[n.Camera]
+		if d == nil {
+			d = &digestSummary{first: n.TS}
+			t.digest[n.Camera] = d
+		}
+		d.count++
+		if d.best == nil || n.MaxDiff > d.best.MaxDiff {
+			d.best = digestPreview(n)
+		}
+		t.mu.Unlock()
+		t.count(metrics.ResultQueued, nil)
+		return nil
+	}
+
+	if t.conf.QuietHours != nil && t.conf.QuietHours.Active(n.TS.In(t.loc)) {
+		t.mu.Unlock()
+		t.lgr.Info("notify_suppressed", "notifier", t.Name(), "reason", "quiet_hours", "event_id", n.EventID)
+		t.count(metrics.ResultSuppressed, nil)
+		return nil
+	}
+
+	if t.conf.Cooldown > 0 && n.TS.Sub(st.lastSent) < t.conf.Cooldown {
+		t.mu.Unlock()
+		t.lgr.Info("notify_suppressed", "notifier", t.Name(), "reason", "cooldown", "event_id", n.EventID)
+		t.count(metrics.ResultSuppressed, nil)
+		return nil
+	}
+
+	st.sent = true
+	st.lastSent = n.TS
+	t.mu.Unlock()
+
+	err := t.inner.Notify(ctx, n)
+	t.count(metrics.ResultSent, err)
+	return err
+}
+
+// count records the result of a notification. err overrides result.
+func (t *Throttle) count(result string, err error) {
+	if err != nil {
+		result = metrics.ResultError
+	}
+	metrics.Notifications.WithLabelValues(t.Name(), result).Inc()
+}
+
+// RunDigest sends the daily digest at the configured time until ctx is
+// done. It does nothing if digest mode is not enabled.
+func (t *Throttle) RunDigest(ctx context.Context) {
+	if t.conf.DigestAt == "" {
+		return
+	}
+
+	for {
+		next := nextClock(time.Now().In(t.loc), t.conf.DigestAt)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		t.sendDigest(ctx)
+	}
+}
+
+func (t *Throttle) sendDigest(ctx context.Context) {
+	t.mu.Lock()
+	pending := t.digest
+	t.digest = make(map[string]*digestSummary)
+	t.mu.Unlock()
+
+	for camera, d := range pending {
+		out := *d.best
+		out.Digest = d.count
+		out.Title = fmt.Sprintf("%d motion events since %s, most active: %s", d.count, d.first.In(t.loc).Format(time.RFC3339), d.best.Title)
+
+		err := t.inner.Notify(ctx, &out)
+		t.count(metrics.ResultSent, err)
+		if err != nil {
+			t.lgr.Error("notify_digest_err", "notifier", t.Name(), "camera", camera, "err", err)
+		}
+	}
+}
+
+// digestPreview copies n without the mp4 and with only one image, the best
+// frame if there is one.
+func digestPreview(n *Notification) *Notification {
+	p := *n
+	p.MP4 = nil
+	if p.BestFrameJPG != nil {
+		p.TiledJPG = nil
+	}
+	return &p
+}
+
+// nextClock returns the next time after now that the wall clock reads
+// clock ("HH:MM").
+func nextClock(now time.Time, clock string) time.Time {
+	c, _ := time.Parse("15:04", clock)
+	next := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/metrics"
+	"github.com/psanford/rom-cam/schedule"
+)
+
+// notificationCount returns a func that reports how much the notification
+// counter has grown since notificationCount was called. The counters are
+// global so tests compare deltas.
+func notificationCount(t *testing.T, notifier, result string) func() float64 {
+	t.Helper()
+	read := func() float64 {
+		var m dto.Metric
+		if err := metrics.Notifications.WithLabelValues(notifier, result).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	start := read()
+	return func() float64 {
+		return read() - start
+	}
+}
+
+type countingNotifier struct {
+	name     string
+	notified int
+	last     *Notification
+	sent     []string
+}
+
+func (c *countingNotifier) Name() string {
+	return c.name
+}
+
+func (c *countingNotifier) Notify(ctx context.Context, n *Notification) error {
+	c.notified++
+	c.last = n
+	c.sent = append(c.sent, n.EventID)
+	return nil
+}
+
+type updatingNotifier struct {
+	countingNotifier
+	updates []int
+}
+
+func (u *updatingNotifier) Update(ctx context.Context, n *Notification) error {
+	u.updates = append(u.updates, n.Segments)
+	return nil
+}
+
+func discardLogger() log15.Logger {
+	lgr := log15.New()
+	lgr.SetHandler(log15.DiscardHandler())
+	return lgr
+}
+
+func TestThrottleAggregate(t *testing.T) {
+	lgr := discardLogger()
+	conf := config.Notify{AggregateWindow: time.Minute}
+
+	start := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	send := func(th *Throttle) {
+		for i, offset := range []time.Duration{0, 10 * time.Second, 20 * time.Second} {
+			n := testNotification()
+			n.EventID = "evt" + string(rune('a'+i))
+			n.TS = start.Add(offset)
+			if err := th.Notify(context.Background(), n); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	plain := &countingNotifier{name: "throttle_test_plain"}
+	suppressed := notificationCount(t, plain.name, metrics.ResultSuppressed)
+	send(NewThrottle(lgr, plain, conf, time.UTC))
+	if plain.notified != 1 {
+		t.Errorf("plain notifier notified %d times, want 1", plain.notified)
+	}
+	// follow-ups it can't edit in are counted, not dropped silently
+	if got := suppressed(); got != 2 {
+		t.Errorf("suppressed = %v, want 2", got)
+	}
+
+	updating := &updatingNotifier{countingNotifier: countingNotifier{name: "throttle_test_updating"}}
+	updated := notificationCount(t, updating.name, metrics.ResultUpdated)
+	send(NewThrottle(lgr, updating, conf, time.UTC))
+	if updating.notified != 1 || len(updating.updates) != 2 || updating.updates[1] != 3 {
+		t.Errorf("updating notifier notified %d times, updates %v", updating.notified, updating.updates)
+	}
+	if got := updated(); got != 2 {
+		t.Errorf("updated = %v, want 2", got)
+	}
+}
+
+func TestThrottleDigest(t *testing.T) {
+	inner := &countingNotifier{name: "throttle_test_digest"}
+	th := NewThrottle(discardLogger(), inner, config.Notify{DigestAt: "08:00"}, time.UTC)
+	queued := notificationCount(t, inner.name, metrics.ResultQueued)
+
+	start := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	for i, maxDiff := range []int{5, 9, 3} {
+		n := testNotification()
+		n.EventID = "evt" + string(rune('a'+i))
+		n.TS = start.Add(time.Duration(i) * time.Hour)
+		n.MaxDiff = maxDiff
+		n.BestFrameJPG = []byte{byte(maxDiff)}
+		n.MP4 = []byte("mp4")
+		if err := th.Notify(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inner.notified != 0 {
+		t.Fatalf("notified %d times before the digest", inner.notified)
+	}
+	if got := queued(); got != 3 {
+		t.Errorf("queued = %v, want 3", got)
+	}
+
+	// only the best event's preview is held until the digest
+	d := th.digest["Front Door"]
+	if d.best.MP4 != nil || d.best.TiledJPG != nil {
+		t.Errorf("digest holds media: mp4 %q tiled %q", d.best.MP4, d.best.TiledJPG)
+	}
+
+	th.sendDigest(context.Background())
+	if inner.notified != 1 {
+		t.Fatalf("digest notified %d times, want 1", inner.notified)
+	}
+	got := inner.last
+	if got.Digest != 3 || got.EventID != "evtb" || string(got.BestFrameJPG) != "\x09" {
+		t.Errorf("digest = %d events, best %s %x", got.Digest, got.EventID, got.BestFrameJPG)
+	}
+	wantTitle := `3 motion events since 2023-01-02T15:04:05Z, most active: Front Door "porch"`
+	if got.Title != wantTitle {
+		t.Errorf("title = %q, want %q", got.Title, wantTitle)
+	}
+
+	// the queue is empty after a flush
+	th.sendDigest(context.Background())
+	if inner.notified != 1 {
+		t.Errorf("empty digest sent")
+	}
+}
+
+func TestThrottleSuppress(t *testing.T) {
+	start := time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		conf     config.Notify
+		offsets  []time.Duration
+		wantSent []string
+	}{
+		{
+			name:     "cooldown",
+			conf:     config.Notify{Cooldown: 5 * time.Minute},
+			offsets:  []time.Duration{0, time.Minute, 4*time.Minute + 59*time.Second, 5 * time.Minute},
+			wantSent: []string{"evta", "evtd"},
+		},
+		{
+			name: "quiet_hours",
+			conf: config.Notify{QuietHours: schedule.Schedule{{Start: "22:00", End: "06:00"}}},
+			// 12:00, 22:00, 05:59 the next day, 06:00
+			offsets:  []time.Duration{0, 10 * time.Hour, 17*time.Hour + 59*time.Minute, 18 * time.Hour},
+			wantSent: []string{"evta", "evtd"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &countingNotifier{name: "throttle_test_" + tt.name}
+			th := NewThrottle(discardLogger(), inner, tt.conf, time.UTC)
+			suppressed := notificationCount(t, inner.name, metrics.ResultSuppressed)
+			for i, offset := range tt.offsets {
+				n := testNotification()
+				n.EventID = "evt" + string(rune('a'+i))
+				n.TS = start.Add(offset)
+				if err := th.Notify(context.Background(), n); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if strings.Join(inner.sent, " ") != strings.Join(tt.wantSent, " ") {
+				t.Errorf("sent %v, want %v", inner.sent, tt.wantSent)
+			}
+			wantSuppressed := float64(len(tt.offsets) - len(tt.wantSent))
+			if got := suppressed(); got != wantSuppressed {
+				t.Errorf("suppressed = %v, want %v", got, wantSuppressed)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/psanford/rom-cam/config"
+)
+
+// SignatureHeader holds the hex encoded HMAC-SHA256 of the request body
+// when a webhook secret is configured.
+const SignatureHeader = "X-Rom-Cam-Signature"
+
+// Webhook posts a JSON document to an arbitrary URL.
+type Webhook struct {
+	conf   config.Webhook
+	tmpl   *template.Template
+	client *http.Client
+}
+
+func NewWebhook(conf config.Webhook) (*Webhook, error) {
+	w := &Webhook{
+		conf:   conf,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+
+	if conf.Template != "" {
+		tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+			"json": toJSON,
+		}).Parse(conf.Template)
+		if err != nil {
+			return nil, fmt.Errorf("parse webhook template err: %w", err)
+		}
+		w.tmpl = tmpl
+	}
+
+	return w, nil
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Notify(ctx context.Context, n *Notification) error {
+	body, err := w.render(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.conf.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign([]byte(w.conf.Secret), body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned status %d", w.conf.URL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (w *Webhook) render(n *Notification) ([]byte, error) {
+	if w.tmpl == nil {
+		return json.Marshal(n)
+	}
+
+	var buf bytes.Buffer
+	err := w.tmpl.Execute(&buf, n)
+	if err != nil {
+		return nil, fmt.Errorf("execute webhook template err: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// toJSON lets templates safely embed values, e.g. {"title": {{json .Title}}}.
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// Update posts follow up notifications to the same URL. Receivers can
+// use the event_id and update fields to merge them.
+func (w *Webhook) Update(ctx context.Context, n *Notification) error {
+	return w.Notify(ctx, n)
+}
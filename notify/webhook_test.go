@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/event"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookServer records the requests it gets and answers with status.
+func webhookServer(t *testing.T, status int) (*httptest.Server, <-chan webhookRequest) {
+	t.Helper()
+	reqs := make(chan webhookRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		reqs <- webhookRequest{header: r.Header, body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, reqs
+}
+
+func testNotification() *Notification {
+	return &Notification{
+		Event: event.Event{
+			Camera: "Front Door",
+			TS:     time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC),
+			Frames: 12,
+			Zones:  []string{"porch"},
+		},
+		Title:    `Front Door "porch"`,
+		EventID:  "evt1",
+		Segments: 2,
+		ClipURL:  "https://example.com/clip.mp4",
+		TiledJPG: []byte("not sent"),
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	srv, reqs := webhookServer(t, 204)
+
+	w, err := NewWebhook(config.Webhook{
+		URL: srv.URL,
+		Headers: map[string]string{
+			"Authorization": "Bearer abc",
+			"X-Extra":       "1",
+		},
+		Secret:   "s3cret",
+		Template: `{"text": {{json .Title}}, "zones": {{json .Zones}}, "clip": "{{.ClipURL}}"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	req := <-reqs
+
+	want := `{"text": "Front Door \"porch\"", "zones": ["porch"], "clip": "https://example.com/clip.mp4"}`
+	if string(req.body) != want {
+		t.Errorf("body = %s, want %s", req.body, want)
+	}
+
+	for k, v := range map[string]string{
+		"Content-Type":  "application/json",
+		"Authorization": "Bearer abc",
+		"X-Extra":       "1",
+	} {
+		if got := req.header.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+
+	// receivers verify with the secret and the raw body
+	wantSig := "sha256=" + Sign([]byte("s3cret"), req.body)
+	if got := req.header.Get(SignatureHeader); got != wantSig {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, wantSig)
+	}
+}
+
+func TestWebhookDefaultBody(t *testing.T) {
+	srv, reqs := webhookServer(t, 200)
+
+	w, err := NewWebhook(config.Webhook{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	req := <-reqs
+
+	if sig := req.header.Get(SignatureHeader); sig != "" {
+		t.Errorf("signed without a secret: %q", sig)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("body is not json: %s", req.body)
+	}
+	for k, v := range map[string]interface{}{
+		"camera":   "Front Door",
+		"title":    `Front Door "porch"`,
+		"event_id": "evt1",
+		"segments": 2.0,
+		"clip_url": "https://example.com/clip.mp4",
+	} {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+	if _, ok := got["TiledJPG"]; ok {
+		t.Error("images are included in the body")
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	if _, err := NewWebhook(config.Webhook{URL: "http://localhost", Template: "{{.Title"}); err == nil {
+		t.Error("invalid template accepted")
+	}
+
+	srv, reqs := webhookServer(t, 500)
+	w, err := NewWebhook(config.Webhook{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Notify(context.Background(), testNotification()); err == nil {
+		t.Error("no error for a 500 response")
+	}
+	<-reqs
+}
+
+func TestSign(t *testing.T) {
+	// RFC 4231 test case 2
+	got := Sign([]byte("Jefe"), []byte("what do ya want for nothing?"))
+	want := "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+package presence
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ARP checks the kernel neighbor table for a complete entry matching one
+// of the configured mac or ip addresses.
+type ARP struct {
+	path string
+	macs map[string]bool
+	ips  map[string]bool
+}
+
+func NewARP(path string, macs, ips []string) *ARP {
+	if path == "" {
+		path = "/proc/net/arp"
+	}
+	return &ARP{
+		path: path,
+		macs: normalizeSet(macs),
+		ips:  normalizeSet(ips),
+	}
+}
+
+func (a *ARP) Name() string {
+	return "arp"
+}
+
+// atfComplete is the ATF_COM flag for a resolved neighbor entry.
+const atfComplete = 0x2
+
+func (a *ARP) Present(ctx context.Context) (bool, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	// IP address  HW type  Flags  HW address  Mask  Device
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		if err != nil || flags&atfComplete == 0 {
+			continue
+		}
+		if a.ips[strings.ToLower(fields[0])] || a.macs[strings.ToLower(fields[3])] {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+func normalizeSet(vals []string) map[string]bool {
+	set := make(map[string]bool, len(vals))
+	for _, v := range vals {
+		set[strings.ToLower(strings.TrimSpace(v))] = true
+	}
+	return set
+}
@@ -0,0 +1,37 @@
+package presence
+
+import (
+	"context"
+	"testing"
+)
+
+func TestARP(t *testing.T) {
+	const table = `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.10     0x1         0x2         aa:bb:cc:dd:ee:01     *        wlan0
+192.168.1.11     0x1         0x0         aa:bb:cc:dd:ee:02     *        wlan0
+192.168.1.12     0x1         0x6         AA:BB:CC:DD:EE:03     *        wlan0
+`
+	path := writeFixture(t, "arp", table)
+
+	tests := []struct {
+		name string
+		macs []string
+		ips  []string
+		want bool
+	}{
+		{name: "mac", macs: []string{"AA:BB:CC:DD:EE:01"}, want: true},
+		{name: "ip", ips: []string{"192.168.1.10"}, want: true},
+		{name: "incomplete entry", macs: []string{"aa:bb:cc:dd:ee:02"}, ips: []string{"192.168.1.11"}, want: false},
+		{name: "upper case table", macs: []string{"aa:bb:cc:dd:ee:03"}, want: true},
+		{name: "no match", macs: []string{"aa:bb:cc:dd:ee:99"}, want: false},
+	}
+	for _, tt := range tests {
+		got, err := NewARP(path, tt.macs, tt.ips).Present(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: present = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
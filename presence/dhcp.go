@@ -0,0 +1,100 @@
+package presence
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DHCP checks a lease file for an unexpired lease for one of the
+// configured mac addresses or hostnames. Both dnsmasq and ISC dhcpd
+// lease files are supported.
+type DHCP struct {
+	path      string
+	macs      map[string]bool
+	hostnames map[string]bool
+}
+
+func NewDHCP(path string, macs, hostnames []string) *DHCP {
+	return &DHCP{
+		path:      path,
+		macs:      normalizeSet(macs),
+		hostnames: normalizeSet(hostnames),
+	}
+}
+
+func (d *DHCP) Name() string {
+	return "dhcp"
+}
+
+func (d *DHCP) Present(ctx context.Context) (bool, error) {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	now := time.Now()
+
+	var (
+		scanner = bufio.NewScanner(f)
+
+		// ISC dhcpd lease block state
+		inLease  bool
+		ends     time.Time
+		mac      string
+		hostname string
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+		if len(fields) == 0 {
+			continue
+		}
+
+		if inLease {
+			switch {
+			case fields[0] == "}":
+				inLease = false
+				if (ends.IsZero() || ends.After(now)) && d.match(mac, hostname) {
+					return true, nil
+				}
+			case fields[0] == "ends" && len(fields) >= 4:
+				// ends 2 2023/01/02 15:04:05
+				ends, _ = time.Parse("2006/01/02 15:04:05", fields[2]+" "+fields[3])
+			case fields[0] == "hardware" && len(fields) >= 3:
+				mac = fields[2]
+			case fields[0] == "client-hostname" && len(fields) >= 2:
+				hostname = strings.Trim(fields[1], `"`)
+			}
+			continue
+		}
+
+		if fields[0] == "lease" && len(fields) >= 3 && fields[2] == "{" {
+			inLease = true
+			ends, mac, hostname = time.Time{}, "", ""
+			continue
+		}
+
+		// dnsmasq: <expiry unix> <mac> <ip> <hostname> <client id>
+		if len(fields) >= 4 {
+			expiry, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			if (expiry == 0 || time.Unix(expiry, 0).After(now)) && d.match(fields[1], fields[3]) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+func (d *DHCP) match(mac, hostname string) bool {
+	return d.macs[strings.ToLower(mac)] || d.hostnames[strings.ToLower(hostname)]
+}
@@ -0,0 +1,115 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFixture writes contents to a file in a temp dir and returns its path.
+func writeFixture(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDHCPDnsmasq(t *testing.T) {
+	now := time.Now()
+	leases := fmt.Sprintf(`%d aa:bb:cc:dd:ee:01 192.168.1.10 alice-phone 01:aa:bb:cc:dd:ee:01
+%d aa:bb:cc:dd:ee:02 192.168.1.11 bob-phone *
+0 aa:bb:cc:dd:ee:03 192.168.1.12 * *
+duid 00:01:00:01:2b:3c:4d:5e:aa:bb:cc:dd:ee:ff
+`, now.Add(time.Hour).Unix(), now.Add(-time.Minute).Unix())
+	path := writeFixture(t, "dnsmasq.leases", leases)
+
+	tests := []struct {
+		name      string
+		macs      []string
+		hostnames []string
+		want      bool
+	}{
+		{name: "mac", macs: []string{"aa:bb:cc:dd:ee:01"}, want: true},
+		{name: "mac upper case", macs: []string{" AA:BB:CC:DD:EE:01 "}, want: true},
+		{name: "hostname", hostnames: []string{"Alice-Phone"}, want: true},
+		{name: "expired", macs: []string{"aa:bb:cc:dd:ee:02"}, hostnames: []string{"bob-phone"}, want: false},
+		{name: "infinite lease", macs: []string{"aa:bb:cc:dd:ee:03"}, want: true},
+		{name: "no match", macs: []string{"aa:bb:cc:dd:ee:99"}, want: false},
+	}
+	for _, tt := range tests {
+		got, err := NewDHCP(path, tt.macs, tt.hostnames).Present(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: present = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDHCPISC(t *testing.T) {
+	const layout = "2006/01/02 15:04:05"
+	now := time.Now().UTC()
+	future := now.Add(time.Hour).Format(layout)
+	past := now.Add(-time.Hour).Format(layout)
+
+	leases := fmt.Sprintf(`# The format of this file is documented in the dhcpd.leases(5) manual page.
+authoring-byte-order little-endian;
+
+lease 192.168.1.10 {
+  starts 1 %[2]s;
+  ends 1 %[1]s;
+  binding state active;
+  hardware ethernet AA:BB:CC:DD:EE:01;
+  client-hostname "alice-phone";
+}
+lease 192.168.1.11 {
+  starts 1 %[2]s;
+  ends 1 %[2]s;
+  binding state free;
+  hardware ethernet aa:bb:cc:dd:ee:02;
+  client-hostname "bob-phone";
+}
+lease 192.168.1.12 {
+  starts 1 %[2]s;
+  ends never;
+  hardware ethernet aa:bb:cc:dd:ee:03;
+}
+`, future, past)
+	path := writeFixture(t, "dhcpd.leases", leases)
+
+	tests := []struct {
+		name      string
+		macs      []string
+		hostnames []string
+		want      bool
+	}{
+		{name: "mac lower case", macs: []string{"aa:bb:cc:dd:ee:01"}, want: true},
+		{name: "hostname", hostnames: []string{"alice-phone"}, want: true},
+		{name: "expired mac", macs: []string{"aa:bb:cc:dd:ee:02"}, want: false},
+		{name: "expired hostname", hostnames: []string{"BOB-PHONE"}, want: false},
+		{name: "never ends", macs: []string{"AA:BB:CC:DD:EE:03"}, want: true},
+		{name: "no match", macs: []string{"aa:bb:cc:dd:ee:99"}, hostnames: []string{"carol"}, want: false},
+	}
+	for _, tt := range tests {
+		got, err := NewDHCP(path, tt.macs, tt.hostnames).Present(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: present = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDHCPMissingFile(t *testing.T) {
+	d := NewDHCP(filepath.Join(t.TempDir(), "missing"), []string{"aa:bb:cc:dd:ee:01"}, nil)
+	if _, err := d.Present(context.Background()); err == nil {
+		t.Error("no error for a missing lease file")
+	}
+}
@@ -0,0 +1,58 @@
+package presence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Endpoint is a push based provider. An external system (e.g. a phone
+// geofence automation) reports that someone is home by calling Set.
+type Endpoint struct {
+	ttl     time.Duration
+	changes chan struct{}
+
+	mu      sync.Mutex
+	home    bool
+	expires time.Time
+}
+
+// NewEndpoint returns an endpoint provider. If ttl is non-zero a home
+// report expires after ttl unless it is refreshed.
+func NewEndpoint(ttl time.Duration) *Endpoint {
+	return &Endpoint{
+		ttl:     ttl,
+		changes: make(chan struct{}, 1),
+	}
+}
+
+func (e *Endpoint) Name() string {
+	return "endpoint"
+}
+
+func (e *Endpoint) Set(home bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.home = home
+	if e.ttl > 0 {
+		e.expires = time.Now().Add(e.ttl)
+	}
+
+	select {
+	case e.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Endpoint) Changes() <-chan struct{} {
+	return e.changes
+}
+
+func (e *Endpoint) Present(ctx context.Context) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.home && !e.expires.IsZero() && time.Now().After(e.expires) {
+		e.home = false
+	}
+	return e.home, nil
+}
@@ -0,0 +1,30 @@
+package presence
+
+import (
+	"context"
+
+	"github.com/paulstuart/ping"
+)
+
+// Ping sends an icmp echo to each address. This requires raw socket
+// access and misses phones that sleep their wifi.
+type Ping struct {
+	ips []string
+}
+
+func NewPing(ips []string) *Ping {
+	return &Ping{ips: ips}
+}
+
+func (p *Ping) Name() string {
+	return "ping"
+}
+
+func (p *Ping) Present(ctx context.Context) (bool, error) {
+	for _, ip := range p.ips {
+		if err := ping.Pinger(ip, 2); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,121 @@
+package presence
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+)
+
+// Provider reports if any of the devices it watches are present.
+type Provider interface {
+	Name() string
+	Present(ctx context.Context) (bool, error)
+}
+
+// Pusher is implemented by providers that can signal a change without
+// waiting for the next poll.
+type Pusher interface {
+	Changes() <-chan struct{}
+}
+
+// Monitor polls a set of providers. Someone is considered home as soon as
+// any provider reports a device present, and away only after no provider
+// has seen a device for the grace period. onChange is called with the
+// first result and then only when it changes.
+type Monitor struct {
+	providers []Provider
+	interval  time.Duration
+	grace     time.Duration
+	onChange  func(home bool)
+	lgr       log15.Logger
+
+	mu       sync.Mutex
+	home     bool
+	lastSeen time.Time
+	// checked is set after the first check, which always reports the
+	// state so listeners start in sync.
+	checked bool
+}
+
+func NewMonitor(lgr log15.Logger, providers []Provider, interval, grace time.Duration, onChange func(home bool)) *Monitor {
+	return &Monitor{
+		providers: providers,
+		interval:  interval,
+		grace:     grace,
+		onChange:  onChange,
+		lgr:       lgr,
+	}
+}
+
+func (m *Monitor) Home() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.home
+}
+
+// Run polls the providers until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	pushed := make(chan struct{}, 1)
+	for _, p := range m.providers {
+		if pusher, ok := p.(Pusher); ok {
+			go func(changes <-chan struct{}) {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-changes:
+						select {
+						case pushed <- struct{}{}:
+						default:
+						}
+					}
+				}
+			}(pusher.Changes())
+		}
+	}
+
+	for {
+		m.check(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-pushed:
+		case <-time.After(m.interval):
+		}
+	}
+}
+
+// check polls the providers and updates the state as of now.
+func (m *Monitor) check(ctx context.Context, now time.Time) {
+	var seen bool
+	for _, p := range m.providers {
+		present, err := p.Present(ctx)
+		if err != nil {
+			m.lgr.Error("presence_provider_err", "provider", p.Name(), "err", err)
+			continue
+		}
+		if present {
+			seen = true
+			break
+		}
+	}
+
+	m.mu.Lock()
+	if seen {
+		m.lastSeen = now
+	}
+	home := seen || (!m.lastSeen.IsZero() && now.Sub(m.lastSeen) < m.grace)
+	changed := home != m.home || !m.checked
+	m.home = home
+	m.checked = true
+	m.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	m.lgr.Info("presence_changed", "home", home)
+	m.onChange(home)
+}
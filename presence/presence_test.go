@@ -0,0 +1,63 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+)
+
+type fakeProvider struct {
+	present bool
+	err     error
+}
+
+func (f *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeProvider) Present(ctx context.Context) (bool, error) {
+	return f.present, f.err
+}
+
+func TestMonitorAwayGrace(t *testing.T) {
+	lgr := log15.New()
+	lgr.SetHandler(log15.DiscardHandler())
+
+	phone := &fakeProvider{}
+	broken := &fakeProvider{err: errors.New("router unreachable")}
+	var changes []bool
+	m := NewMonitor(lgr, []Provider{broken, phone}, time.Minute, 10*time.Minute, func(home bool) {
+		changes = append(changes, home)
+	})
+
+	start := time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		at      time.Duration
+		present bool
+		home    bool
+	}{
+		{0, false, false},
+		{time.Minute, true, true},
+		// the phone's wifi sleeps
+		{2 * time.Minute, false, true},
+		{11*time.Minute - time.Second, false, true},
+		{11 * time.Minute, false, false},
+		{12 * time.Minute, false, false},
+		{13 * time.Minute, true, true},
+	}
+	for _, step := range steps {
+		phone.present = step.present
+		m.check(context.Background(), start.Add(step.at))
+		if m.Home() != step.home {
+			t.Errorf("at %s: home = %t, want %t", step.at, m.Home(), step.home)
+		}
+	}
+
+	if got := fmt.Sprint(changes); got != "[false true false true]" {
+		t.Errorf("changes = %s, want [false true false true]", got)
+	}
+}
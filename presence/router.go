@@ -0,0 +1,77 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Router fetches a client list from a router's http api and looks for
+// any of the configured strings (typically mac addresses or hostnames)
+// in the response.
+type Router struct {
+	url      string
+	username string
+	password string
+	headers  map[string]string
+	match    []string
+	client   *http.Client
+}
+
+func NewRouter(url, username, password string, headers map[string]string, match []string) *Router {
+	lower := make([]string, 0, len(match))
+	for _, m := range match {
+		lower = append(lower, strings.ToLower(m))
+	}
+	return &Router{
+		url:      url,
+		username: username,
+		password: password,
+		headers:  headers,
+		match:    lower,
+		client:   &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+func (r *Router) Name() string {
+	return "router"
+}
+
+func (r *Router) Present(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", r.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("router status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return false, err
+	}
+
+	clients := strings.ToLower(string(body))
+	for _, m := range r.match {
+		if strings.Contains(clients, m) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
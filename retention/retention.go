@@ -0,0 +1,162 @@
+package retention
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/storage"
+)
+
+// Janitor enforces a retention policy for a single camera's footage.
+type Janitor struct {
+	store  storage.Backend
+	camera string
+	policy config.Retention
+	kinds  []string
+	lgr    log15.Logger
+}
+
+// MotionKinds are the object kinds written for motion events.
+var MotionKinds = []string{storage.KindTS, storage.KindMP4, storage.KindTiled, storage.KindEvent}
+
+// ContinuousKinds are the object kinds written by continuous recording.
+var ContinuousKinds = []string{storage.KindContinuous}
+
+func NewJanitor(lgr log15.Logger, store storage.Backend, camera string, policy config.Retention, kinds []string) *Janitor {
+	return &Janitor{
+		store:  store,
+		camera: camera,
+		policy: policy,
+		kinds:  kinds,
+		lgr:    lgr,
+	}
+}
+
+type Deletion struct {
+	storage.Object
+	Reason string
+}
+
+const (
+	ReasonExpired   = "expired"
+	ReasonVideoAge  = "video_age"
+	ReasonOverLimit = "over_limit"
+)
+
+// Plan returns the objects that the policy says should be deleted as of now.
+// Only keys written by Key for this camera and one of the janitor's kinds
+// are considered; anything else under the prefixes is never deleted.
+func (j *Janitor) Plan(ctx context.Context, now time.Time) ([]Deletion, error) {
+	var objs []object
+	for _, kind := range j.kinds {
+		kindObjs, err := j.store.List(ctx, storage.Prefix(kind, j.camera))
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range kindObjs {
+			k, camera, ts, ok := storage.ParseKey(obj.Key)
+			if !ok || k != kind || camera != j.camera {
+				continue
+			}
+			objs = append(objs, object{Object: obj, kind: k, ts: ts})
+		}
+	}
+
+	// oldest first so the byte cap removes the oldest footage
+	sort.SliceStable(objs, func(a, b int) bool {
+		return objs[a].ts.Before(objs[b].ts)
+	})
+
+	var (
+		deletions []Deletion
+		kept      []storage.Object
+		keptBytes int64
+	)
+
+	for _, o := range objs {
+		obj, kind := o.Object, o.kind
+		age := now.Sub(o.ts)
+
+		if j.policy.KeepDays > 0 && age > days(j.policy.KeepDays) {
+			deletions = append(deletions, Deletion{Object: obj, Reason: ReasonExpired})
+			continue
+		}
+
+		if j.policy.ThumbnailsOnlyAfterDays > 0 && storage.IsVideo(kind) && age > days(j.policy.ThumbnailsOnlyAfterDays) {
+			deletions = append(deletions, Deletion{Object: obj, Reason: ReasonVideoAge})
+			continue
+		}
+
+		kept = append(kept, obj)
+		keptBytes += obj.Size
+	}
+
+	if j.policy.MaxBytes > 0 {
+		for _, obj := range kept {
+			if keptBytes <= j.policy.MaxBytes {
+				break
+			}
+			deletions = append(deletions, Deletion{Object: obj, Reason: ReasonOverLimit})
+			keptBytes -= obj.Size
+		}
+	}
+
+	return deletions, nil
+}
+
+// Sweep plans and executes deletions. If dryRun is set nothing is deleted.
+func (j *Janitor) Sweep(ctx context.Context, dryRun bool) ([]Deletion, error) {
+	deletions, err := j.Plan(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun || len(deletions) == 0 {
+		return deletions, nil
+	}
+
+	keys := make([]string, 0, len(deletions))
+	for _, d := range deletions {
+		keys = append(keys, d.Key)
+	}
+
+	err = j.store.Delete(ctx, keys)
+	return deletions, err
+}
+
+// Run sweeps every policy interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context) {
+	for {
+		deletions, err := j.Sweep(ctx, false)
+		if err != nil {
+			j.lgr.Error("retention_sweep_err", "camera", j.camera, "kinds", j.kinds, "err", err)
+		} else {
+			var size int64
+			for _, d := range deletions {
+				size += d.Size
+			}
+			j.lgr.Info("retention_sweep", "camera", j.camera, "kinds", j.kinds, "deleted", len(deletions), "bytes", size)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(j.policy.Interval):
+		}
+	}
+}
+
+// object is a stored object with the kind and capture time parsed from
+// its key.
+type object struct {
+	storage.Object
+	kind string
+	ts   time.Time
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
@@ -0,0 +1,200 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/storage"
+)
+
+// memStore is a storage.Backend over a fixed list of objects.
+type memStore struct {
+	objs    []storage.Object
+	deletes [][]string
+}
+
+func (m *memStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (m *memStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (m *memStore) List(ctx context.Context, prefix string) ([]storage.Object, error) {
+	var out []storage.Object
+	for _, obj := range m.objs {
+		if strings.HasPrefix(obj.Key, prefix) {
+			out = append(out, obj)
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) Delete(ctx context.Context, keys []string) error {
+	m.deletes = append(m.deletes, keys)
+	return nil
+}
+
+func (m *memStore) SignedURL(key string, expire time.Duration) (string, error) {
+	return "", fmt.Errorf("not implemented")
+}
+
+func discardLogger() log15.Logger {
+	lgr := log15.New()
+	lgr.SetHandler(log15.DiscardHandler())
+	return lgr
+}
+
+const day = 24 * time.Hour
+
+func TestPlan(t *testing.T) {
+	now := time.Date(2023, 6, 10, 12, 0, 0, 0, time.UTC)
+	key := func(kind string, age time.Duration) string {
+		return storage.Key(kind, "front", now.Add(-age))
+	}
+	obj := func(key string, size int64) storage.Object {
+		// modification times are old so they'd expire if they were used
+		return storage.Object{Key: key, Size: size, LastModified: now.Add(-365 * day)}
+	}
+
+	// keys under the camera's prefixes that Key didn't write
+	unknown := []storage.Object{
+		obj("ts/front/notes.txt", 5000),
+		obj("ts/front/sub/1600000000.ts", 5000),
+		obj("tiled/front/1600000000.mp4", 5000),
+		obj("mp4/front/latest.mp4", 5000),
+	}
+
+	tests := []struct {
+		name   string
+		policy config.Retention
+		kinds  []string
+		objs   []storage.Object
+		want   []string
+	}{
+		{
+			name:   "keep_days",
+			policy: config.Retention{KeepDays: 7},
+			kinds:  MotionKinds,
+			objs: []storage.Object{
+				obj(key(storage.KindTS, 7*day+time.Second), 100),
+				obj(key(storage.KindEvent, 7*day), 100),
+				obj(key(storage.KindMP4, 30*day), 100),
+				obj(key(storage.KindTiled, day), 100),
+				// continuous recording has its own janitor
+				obj(key(storage.KindContinuous, 30*day), 100),
+				// another camera whose name shares a prefix
+				obj(storage.Key(storage.KindTS, "front2", now.Add(-30*day)), 100),
+			},
+			want: []string{
+				key(storage.KindMP4, 30*day) + " expired",
+				key(storage.KindTS, 7*day+time.Second) + " expired",
+			},
+		},
+		{
+			name:   "thumbnails_only",
+			policy: config.Retention{KeepDays: 30, ThumbnailsOnlyAfterDays: 2},
+			kinds:  MotionKinds,
+			objs: []storage.Object{
+				obj(key(storage.KindTS, 31*day), 100),
+				obj(key(storage.KindTS, 3*day), 100),
+				obj(key(storage.KindMP4, 3*day), 100),
+				obj(key(storage.KindTiled, 3*day), 100),
+				obj(key(storage.KindEvent, 3*day), 100),
+				obj(key(storage.KindMP4, 2*day), 100),
+			},
+			want: []string{
+				key(storage.KindTS, 31*day) + " expired",
+				key(storage.KindTS, 3*day) + " video_age",
+				key(storage.KindMP4, 3*day) + " video_age",
+			},
+		},
+		{
+			name:   "max_bytes",
+			policy: config.Retention{MaxBytes: 250},
+			kinds:  MotionKinds,
+			objs: []storage.Object{
+				obj(key(storage.KindTS, 1*day), 100),
+				obj(key(storage.KindTS, 3*day), 100),
+				obj(key(storage.KindTS, 2*day), 100),
+				obj(key(storage.KindTS, 4*day), 100),
+			},
+			want: []string{
+				key(storage.KindTS, 4*day) + " over_limit",
+				key(storage.KindTS, 3*day) + " over_limit",
+			},
+		},
+		{
+			name:   "continuous",
+			policy: config.Retention{KeepDays: 1},
+			kinds:  ContinuousKinds,
+			objs: []storage.Object{
+				obj(key(storage.KindContinuous, 2*day), 100),
+				obj(key(storage.KindContinuous, day), 100),
+				obj(key(storage.KindTS, 2*day), 100),
+				obj(key(storage.KindEvent, 2*day), 100),
+				obj("continuous/front/1600000000.mp4", 100),
+			},
+			want: []string{
+				key(storage.KindContinuous, 2*day) + " expired",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memStore{objs: append(tt.objs, unknown...)}
+			j := NewJanitor(discardLogger(), store, "front", tt.policy, tt.kinds)
+			deletions, err := j.Plan(context.Background(), now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, d := range deletions {
+				got = append(got, d.Key+" "+d.Reason)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("deletions:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Now()
+	var objs []storage.Object
+	for i := 0; i < 2500; i++ {
+		objs = append(objs, storage.Object{Key: storage.Key(storage.KindTS, "front", now.Add(-10*day-time.Duration(i)*time.Second))})
+	}
+	objs = append(objs, storage.Object{Key: storage.Key(storage.KindTS, "front", now.Add(-day))})
+	store := &memStore{objs: objs}
+	j := NewJanitor(discardLogger(), store, "front", config.Retention{KeepDays: 7}, MotionKinds)
+
+	deletions, err := j.Sweep(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deletions) != 2500 || len(store.deletes) != 0 {
+		t.Fatalf("dry run planned %d deletions and deleted %d batches", len(deletions), len(store.deletes))
+	}
+
+	// batching to the s3 limit is up to the backend
+	if _, err := j.Sweep(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.deletes) != 1 || len(store.deletes[0]) != 2500 {
+		t.Fatalf("deleted %d batches, want 1 of 2500 keys", len(store.deletes))
+	}
+	for _, k := range store.deletes[0] {
+		if k == objs[2500].Key {
+			t.Errorf("deleted %s, which is within keep_days", k)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func armingCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "arming",
+		Short: "Show or override a running daemon's arming mode",
+		Run:   armingAction,
+	}
+
+	cmd.Flags().StringVarP(&serverAddr, "addr", "a", "http://localhost:8080", "Base url of the rom-cam webserver")
+	cmd.Flags().StringVarP(&armMode, "mode", "m", "", "Override mode: disarmed, record_only, armed, armed_notify or auto to clear the override")
+	cmd.Flags().DurationVarP(&armFor, "for", "", 0, "How long the override lasts, default until changed")
+	cmd.Flags().StringVarP(&webUser, "user", "u", "", "Webserver username if web_auth is enabled")
+	cmd.Flags().StringVarP(&webPassword, "password", "p", os.Getenv("ROM_CAM_PASSWORD"), "Webserver password, default $ROM_CAM_PASSWORD")
+
+	return cmd
+}
+
+func armingAction(cmd *cobra.Command, args []string) {
+	u := strings.TrimSuffix(serverAddr, "/") + "/arming"
+
+	var (
+		req *http.Request
+		err error
+	)
+	if armMode == "" {
+		req, err = http.NewRequest("GET", u, nil)
+	} else {
+		v := url.Values{"mode": {armMode}}
+		if armFor > 0 {
+			v.Set("for", armFor.String())
+		}
+		req, err = http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		log.Fatalf("arming request err: %s", err)
+	}
+
+	if webUser != "" {
+		req.SetBasicAuth(webUser, webPassword)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("arming request err: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("arming request status %d: %s", resp.StatusCode, msg)
+	}
+
+	io.Copy(os.Stdout, resp.Body)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func hashPasswordCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "hash-password",
+		Short: "Read a password from stdin and print a bcrypt hash for web_auth users",
+		Run:   hashPasswordAction,
+	}
+
+	return cmd
+}
+
+func hashPasswordAction(cmd *cobra.Command, args []string) {
+	fmt.Fprint(os.Stderr, "password: ")
+	pass, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && pass == "" {
+		log.Fatalf("read password err: %s", err)
+	}
+	pass = strings.TrimRight(pass, "\r\n")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatalf("hash password err: %s", err)
+	}
+	fmt.Println(string(hash))
+}
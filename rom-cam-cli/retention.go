@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/retention"
+	"github.com/psanford/rom-cam/storage"
+	"github.com/spf13/cobra"
+)
+
+func retentionCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "retention",
+		Short: "Apply the configured retention policy to the bucket",
+		Run:   retentionAction,
+	}
+
+	cmd.Flags().StringVarP(&confPath, "config", "c", "", "Path to rom-cam config file")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "Report what would be deleted without deleting anything")
+	cmd.Flags().StringVarP(&cameraName, "camera", "", "", "Camera name to apply the policy to, default from config")
+	cmd.Flags().BoolVarP(&continuous, "continuous", "", false, "Apply the continuous recording policy instead of the motion policy")
+
+	return cmd
+}
+
+func retentionAction(cmd *cobra.Command, args []string) {
+	if confPath == "" {
+		log.Fatalf("--config is required")
+	}
+
+	conf, err := config.LoadConfig(confPath)
+	if err != nil {
+		log.Fatalf("load config err: %s", err)
+	}
+
+	if conf.Bucket == "" {
+		log.Fatalf("no bucket configured")
+	}
+
+	policy := conf.Retention
+	kinds := retention.MotionKinds
+	if continuous {
+		policy = conf.Continuous.Retention
+		kinds = retention.ContinuousKinds
+	}
+
+	if !policy.Enabled() {
+		log.Fatalf("no retention policy configured")
+	}
+
+	if cameraName != "" {
+		conf.Name = cameraName
+	}
+
+	store, err := storage.NewS3(conf.Bucket, conf.AWSCreds)
+	if err != nil {
+		log.Fatalf("init s3 storage err: %s", err)
+	}
+
+	lgr := log15.New()
+	lgr.SetHandler(log15.StreamHandler(os.Stderr, log15.LogfmtFormat()))
+
+	janitor := retention.NewJanitor(lgr, store, conf.NameForFile(), policy, kinds)
+	deletions, err := janitor.Sweep(context.Background(), dryRun)
+	if err != nil {
+		log.Fatalf("retention sweep err: %s", err)
+	}
+
+	var total int64
+	for _, d := range deletions {
+		total += d.Size
+		log.Printf("%s %d %s %s", d.LastModified.Format("2006-01-02T15:04:05"), d.Size, d.Reason, d.Key)
+	}
+
+	verb := "deleted"
+	if dryRun {
+		verb = "would delete"
+	}
+	log.Printf("%s %d objects (%d bytes)", verb, len(deletions), total)
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -28,6 +29,9 @@ func Execute() error {
 	rootCmd.AddCommand(edgeDetectCommand())
 	rootCmd.AddCommand(bgSubtractCommand())
 	rootCmd.AddCommand(blockDetectCommand())
+	rootCmd.AddCommand(retentionCommand())
+	rootCmd.AddCommand(armingCommand())
+	rootCmd.AddCommand(hashPasswordCommand())
 
 	return rootCmd.Execute()
 }
@@ -40,6 +44,15 @@ var (
 	blockThreshold  int
 	minActiveBlocks int
 	showMotion      bool
+	confPath        string
+	dryRun          bool
+	cameraName      string
+	continuous      bool
+	serverAddr      string
+	armMode         string
+	armFor          time.Duration
+	webUser         string
+	webPassword     string
 )
 
 type motionFrame struct {
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -12,28 +13,43 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
-	"sync/atomic"
+	"sync"
 	"time"
 	_ "time/tzdata"
 
 	"github.com/Comcast/gots/packet"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/inconshreveable/log15"
 	"github.com/nareix/joy4/codec/h264parser"
-	"github.com/paulstuart/ping"
+	"github.com/psanford/rom-cam/arming"
 	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/event"
+	"github.com/psanford/rom-cam/feed"
 	"github.com/psanford/rom-cam/kernelmodule"
+	"github.com/psanford/rom-cam/metrics"
+	"github.com/psanford/rom-cam/mjpeg"
+	"github.com/psanford/rom-cam/mqtt"
+	"github.com/psanford/rom-cam/notify"
+	"github.com/psanford/rom-cam/presence"
+	"github.com/psanford/rom-cam/retention"
+	"github.com/psanford/rom-cam/rtsp"
 	"github.com/psanford/rom-cam/segment"
+	"github.com/psanford/rom-cam/storage"
+	"github.com/psanford/rom-cam/tsdemux"
+	"github.com/psanford/rom-cam/webrtc"
 	"github.com/psanford/rom-cam/webserver"
-	"github.com/slack-go/slack"
 )
 
 var (
 	segmentSize = 10 * time.Second // this matches the gop for the logitec cam
+	partTarget  = 1 * time.Second  // low latency hls partial segment size
+
+	// maxGOP is the longest keyframe interval we expect. Segments are cut
+	// at the first keyframe after segmentSize, so none is longer than
+	// segmentSize+maxGOP.
+	maxGOP = 10 * time.Second
+	// captureStallTimeout is how long without a segment before capture is
+	// considered failed.
+	captureStallTimeout = 2 * (segmentSize + maxGOP)
 
 	confPath = flag.String("config", "", "Path to config file")
 
@@ -48,6 +64,13 @@ const (
 	H264NonIDRFrame = 5
 )
 
+// maxRecentEvents is how many events are kept in memory for the web UI.
+const maxRecentEvents = 20
+
+// notifyQueueSize is how many notifications, each with its clip and
+// images, may wait for a slow notifier before new ones are dropped.
+const notifyQueueSize = 4
+
 func main() {
 	flag.Parse()
 
@@ -79,57 +102,240 @@ func main() {
 
 	s := server{
 		conf: *conf,
-		ring: segment.NewRing(3),
+		ring: segment.NewRing(conf.Ring.Duration, conf.Ring.MaxBytes),
+		live: segment.NewLive(partTarget, 3),
+		feed: feed.New(),
+
+		resetChan: make(chan struct{}, 1),
+	}
+	s.mjpeg = mjpeg.NewDecoder(lgr, s.live, ffmpegPath, conf.MJPEG.FPS)
+	metrics.RegisterRing(s.ring)
+
+	// the arming and presence callbacks publish to mqtt, so the client
+	// is created before they start. It connects once arming is set up.
+	if conf.MQTT != nil {
+		s.mqttClient = mqtt.New(lgr, *conf.MQTT, conf.Name, conf.NameForFile(), &s)
+	}
+
+	s.arming, err = arming.NewManager(conf.Arming, loc, func(state arming.State) {
+		lgr.Info("arming_mode_changed", "mode", state.Mode, "reason", state.Reason, "source", state.Source)
+		s.feed.Publish(feed.TypeArming, state)
+		metrics.SetArmingMode(state.Mode)
+		if s.mqttClient != nil {
+			s.mqttClient.PublishArming(state)
+		}
+	})
+	if err != nil {
+		log.Fatalf("init arming err: %s", err)
+	}
+	metrics.SetArmingMode(s.arming.State().Mode)
+	go s.arming.Run(ctx)
+
+	if conf.Bucket != "" {
+		store, err := storage.NewS3(conf.Bucket, conf.AWSCreds)
+		if err != nil {
+			log.Fatalf("init s3 storage err: %s", err)
+		}
+		s.store = storage.WithMetrics(store)
+
+		if conf.Retention.Enabled() {
+			janitor := retention.NewJanitor(lgr, store, conf.NameForFile(), conf.Retention, retention.MotionKinds)
+			go janitor.Run(ctx)
+		}
+
+		if conf.Continuous.Enabled && conf.Continuous.Retention.Enabled() {
+			janitor := retention.NewJanitor(lgr, store, conf.NameForFile(), conf.Continuous.Retention, retention.ContinuousKinds)
+			go janitor.Run(ctx)
+		}
+	}
+
+	if conf.SlackBotToken != "" {
+		if conf.SlackChannel == "" {
+			log.Fatalf("slack_bot_token requires slack_channel")
+		}
+		s.notifiers = append(s.notifiers, notify.NewSlackBot(conf.SlackBotToken, conf.SlackChannel))
+	} else if conf.WebhookURL != "" {
+		s.notifiers = append(s.notifiers, notify.NewSlack(conf.WebhookURL))
+	}
+
+	for _, whConf := range conf.Webhooks {
+		wh, err := notify.NewWebhook(whConf)
+		if err != nil {
+			log.Fatalf("init webhook notifier err: %s", err)
+		}
+		s.notifiers = append(s.notifiers, wh)
+	}
+
+	for _, emailConf := range conf.Emails {
+		email, err := notify.NewEmail(emailConf)
+		if err != nil {
+			log.Fatalf("init email notifier err: %s", err)
+		}
+		s.notifiers = append(s.notifiers, email)
+	}
+
+	for _, ntfyConf := range conf.Ntfy {
+		ntfy, err := notify.NewNtfy(ntfyConf)
+		if err != nil {
+			log.Fatalf("init ntfy notifier err: %s", err)
+		}
+		s.notifiers = append(s.notifiers, ntfy)
+	}
+
+	for _, gotifyConf := range conf.Gotify {
+		gotify, err := notify.NewGotify(gotifyConf)
+		if err != nil {
+			log.Fatalf("init gotify notifier err: %s", err)
+		}
+		s.notifiers = append(s.notifiers, gotify)
+	}
+
+	for _, telegramConf := range conf.Telegram {
+		telegram, err := notify.NewTelegram(lgr, telegramConf)
+		if err != nil {
+			log.Fatalf("init telegram notifier err: %s", err)
+		}
+		s.notifiers = append(s.notifiers, telegram)
+		if telegramConf.Commands {
+			go telegram.Listen(ctx, &s)
+		}
+	}
+
+	for _, matrixConf := range conf.Matrix {
+		matrix, err := notify.NewMatrix(lgr, matrixConf)
+		if err != nil {
+			log.Fatalf("init matrix notifier err: %s", err)
+		}
+		s.notifiers = append(s.notifiers, matrix)
+		if matrixConf.Commands {
+			go matrix.Listen(ctx, &s)
+		}
+	}
+
+	// each notifier sends from its own queue so a slow endpoint can't
+	// stall the segment loop and with it the live streams
+	for i, notifier := range s.notifiers {
+		throttle := notify.NewThrottle(lgr, notifier, conf.Notify, loc)
+		go throttle.RunDigest(ctx)
+		queue := notify.NewQueue(lgr, throttle, notifyQueueSize)
+		go queue.Run(ctx)
+		s.notifiers[i] = queue
+	}
+
+	if s.mqttClient != nil {
+		s.mqttClient.Connect()
+	}
+
+	var presenceEndpoint *presence.Endpoint
+	if conf.Presence.Endpoint != nil {
+		presenceEndpoint = presence.NewEndpoint(conf.Presence.Endpoint.TTL)
+	}
+
+	var rtcServer *webrtc.Server
+	if conf.WebRTC != nil {
+		rtcServer, err = webrtc.NewServer(lgr, s.live, *conf.WebRTC)
+		if err != nil {
+			log.Fatalf("init webrtc err: %s", err)
+		}
+		go func() {
+			err := rtcServer.Run(ctx)
+			if err != nil {
+				lgr.Error("webrtc_run_err", "err", err)
+			}
+		}()
+	}
+
+	if conf.RTSP != nil {
+		rtspServer, err := rtsp.NewServer(lgr, s.live, *conf.RTSP)
+		if err != nil {
+			log.Fatalf("init rtsp err: %s", err)
+		}
+		go func() {
+			lgr.Info("starting_rtsp", "addr", conf.RTSP.ListenAddr)
+			err := rtspServer.Run(ctx)
+			if err != nil {
+				lgr.Error("rtsp_run_err", "err", err)
+			}
+		}()
 	}
 
 	if conf.WebserverListenAddr != "" {
+		opts := webserver.Options{
+			Camera:   conf.Name,
+			Status:   &s,
+			Store:    s.store,
+			Location: loc,
+			Live:     s.live,
+			MJPEG:    s.mjpeg,
+			WebRTC:   rtcServer,
+			Presence: presenceEndpoint,
+			Arming:   s.arming,
+			Config:   conf,
+			Control:  &s,
+			Feed:     s.feed,
+			Auth:     conf.WebAuth,
+			TLS:      conf.WebTLS,
+
+			TargetDuration: segmentSize + maxGOP,
+		}
+		if presenceEndpoint != nil {
+			opts.PresenceToken = conf.Presence.Endpoint.Token
+		}
+
 		go func() {
 			lgr.Info("starting_webserver", "addr", conf.WebserverListenAddr)
-			err := webserver.ListenAndServe(lgr, s.ring, ffmpegPath, conf.WebserverListenAddr)
+			err := webserver.ListenAndServe(lgr, s.ring, ffmpegPath, conf.WebserverListenAddr, opts)
 			if err != nil {
 				lgr.Error("listen and serve err: %s", err)
 			}
 		}()
 	}
 
-	go s.watchForHomeDevices()
+	go s.watchForHomeDevices(ctx, lgr, presenceEndpoint)
 
 	s.run(ctx, lgr)
 }
 
 type server struct {
-	conf          config.Config
-	ring          *segment.Ring
-	someoneIsHome int32
+	conf       config.Config
+	ring       *segment.Ring
+	live       *segment.Live
+	feed       *feed.Feed
+	mjpeg      *mjpeg.Decoder
+	store      storage.Backend
+	notifiers  []notify.Notifier
+	mqttClient *mqtt.Client
+	arming     *arming.Manager
+	resetChan  chan struct{}
+
+	mu           sync.Mutex
+	motion       bool
+	recentEvents []event.Event
+	recordNext   bool
+	lastSegment  time.Time
+	detector     webserver.DetectorStats
+	// detectTime is the total time spent checking segments for motion.
+	detectTime time.Duration
 }
 
 func (s *server) run(ctx context.Context, lgr log15.Logger) {
-	var creds *credentials.Credentials
-	if s.conf.AWSCreds != nil {
-		creds = credentials.NewStaticCredentials(s.conf.AWSCreds.AccessKeyID, s.conf.AWSCreds.SecretAccessKey, "")
-	}
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String("us-east-1"),
-		Credentials: creds,
-	})
-	if err != nil {
-		panic(err)
-	}
-	s3client := s3.New(sess)
-
 	segmentChan := make(chan segment.Segment, 1)
 
-	resetChan := make(chan struct{}, 1)
-
 	safeCameraName := s.conf.NameForFile()
 
-	err = captureSource(ctx, lgr, resetChan, segmentChan)
+	err := captureSource(ctx, lgr, s.resetChan, segmentChan, s.live)
 	if err != nil {
 		panic(err)
 	}
+	go s.watchCapture(ctx)
 
 	for segment := range segmentChan {
 		s.ring.Push(segment)
+		s.captured()
+		metrics.SegmentsCaptured.Inc()
+		metrics.SegmentBytes.Observe(float64(len(segment.Data)))
+		metrics.SegmentDuration.Observe(segment.Duration.Seconds())
+		s.publishSegment()
 
 		if s.conf.SaveTSDir != "" {
 			fp := filepath.Join(s.conf.SaveTSDir, fmt.Sprintf("%d.ts", segment.TS.Unix()))
@@ -137,153 +343,444 @@ func (s *server) run(ctx context.Context, lgr log15.Logger) {
 			lgr.Info("wrote_local_file", "path", fp)
 		}
 
-		motionFrames, err := hasMotion(ctx, lgr, segment)
+		var continuousKey string
+		if s.recordContinuous(segment.TS) {
+			continuousKey = storage.Key(storage.KindContinuous, safeCameraName, segment.TS)
+			go func(key string, data []byte) {
+				err := s.store.Put(ctx, key, data, "video/mp2t")
+				if err != nil {
+					lgr.Error("continuous_put_obj_err", "key", key, "err", err)
+				}
+			}(continuousKey, segment.Data)
+		}
+
+		detectStart := time.Now()
+		motionFrames, err := hasMotion(ctx, lgr, segment, s.conf.Zones, s.publishScore(segment))
+		s.recordDetection(time.Since(detectStart), motionFrames, err)
 		if err != nil {
 			lgr.Error("has_motion_err_trigger_reset", "err", err)
-			resetChan <- struct{}{}
+			s.ResetCapture()
 			continue
 		}
 
-		if len(motionFrames) > 1 {
-			isHome := atomic.LoadInt32(&s.someoneIsHome) > 0
-			lgr.Info("motion-detected", "frames", len(motionFrames), "is_home_dont_save", isHome)
+		s.setMotion(len(motionFrames) > 1)
+
+		manual := s.takeRecordNext()
+
+		if len(motionFrames) > 1 || manual {
+			armState := s.arming.State()
+			if manual {
+				lgr.Info("manual-recording", "frames", len(motionFrames), "mode", armState.Mode, "mode_reason", armState.Reason)
+			} else {
+				lgr.Info("motion-detected", "frames", len(motionFrames), "mode", armState.Mode, "mode_reason", armState.Reason)
+			}
 
-			if isHome {
+			// a manual recording was asked for explicitly, so it is kept
+			// even while disarmed
+			if !armState.Mode.Records() && !manual {
 				continue
 			}
 
-			bestFrame := motionFrames[0]
-			for _, f := range motionFrames[1:] {
-				if f.Diff > bestFrame.Diff {
+			var bestFrame motionFrame
+			for i, f := range motionFrames {
+				if i == 0 || f.Diff > bestFrame.Diff {
 					bestFrame = f
 				}
 			}
 
-			if s.conf.Bucket != "" {
-				tsFilename := fmt.Sprintf("ts/%s/%d.ts", safeCameraName, segment.TS.Unix())
+			ev := event.Event{
+				Camera:     safeCameraName,
+				TS:         segment.TS,
+				Frames:     len(motionFrames),
+				BestFrame:  bestFrame.Idx,
+				MaxDiff:    bestFrame.Diff,
+				Zones:      motionZones(motionFrames),
+				Mode:       string(armState.Mode),
+				ModeReason: armState.String(),
+				Manual:     manual,
+			}
+			if manual {
+				metrics.Events.WithLabelValues("manual").Inc()
+			} else {
+				metrics.Events.WithLabelValues("motion").Inc()
+			}
+
+			n := notify.Notification{
+				Title:    fmt.Sprintf("%s %s", s.conf.Name, segment.TS.In(loc).Format(time.RFC3339)),
+				EventID:  fmt.Sprintf("%s-%d", safeCameraName, segment.TS.Unix()),
+				Segments: 1,
+			}
 
-				_, err = s3client.PutObject(&s3.PutObjectInput{
-					Bucket: &s.conf.Bucket,
-					Key:    &tsFilename,
-					Body:   bytes.NewReader(segment.Data),
-				})
+			var mp4, tiled, bestFrameJPG []byte
+			if s.store != nil || len(s.notifiers) > 0 {
+				mp4, err = toMP4(ctx, segment)
 				if err != nil {
-					lgr.Error("s3_put_obj_err", "err", err)
-					continue
+					lgr.Error("to_mp4_err", "err", err)
 				}
 
-				var mp4Filename, tiledFilename string
+				tiled, err = toTiled(ctx, segment)
+				if err != nil {
+					lgr.Error("to_tiled_err", "err", err)
+				}
+			}
 
-				mp4, err := toMP4(ctx, segment)
+			if s.mqttClient != nil || len(s.notifiers) > 0 {
+				bestFrameJPG, err = toStillJPG(ctx, segment, bestFrame.Idx)
 				if err != nil {
-					lgr.Error("to_mp4_err", "err", err)
+					lgr.Error("to_still_jpg_err", "err", err)
+				}
+			}
+
+			if s.store != nil {
+				if continuousKey != "" {
+					ev.SegmentKey = continuousKey
+					ev.Continuous = true
 				} else {
-					mp4Filename = fmt.Sprintf("mp4/%s/%d.mp4", safeCameraName, segment.TS.Unix())
-					_, err = s3client.PutObject(&s3.PutObjectInput{
-						Bucket:      &s.conf.Bucket,
-						Key:         &mp4Filename,
-						Body:        bytes.NewReader(mp4),
-						ContentType: aws.String("video/mp4"),
-					})
+					tsFilename := storage.Key(storage.KindTS, safeCameraName, segment.TS)
+
+					err = s.store.Put(ctx, tsFilename, segment.Data, "")
 					if err != nil {
 						lgr.Error("s3_put_obj_err", "err", err)
 						continue
 					}
+					ev.SegmentKey = tsFilename
 				}
 
-				tiled, err := toTiled(ctx, segment)
-				if err != nil {
-					lgr.Error("to_tiled_err", "err", err)
-				} else {
-					tiledFilename = fmt.Sprintf("tiled/%s/%d.jpg", safeCameraName, segment.TS.Unix())
-					_, err = s3client.PutObject(&s3.PutObjectInput{
-						Bucket:      &s.conf.Bucket,
-						Key:         &tiledFilename,
-						Body:        bytes.NewReader(tiled),
-						ContentType: aws.String("image/jpeg"),
-					})
+				var mp4Filename, tiledFilename string
+
+				if mp4 != nil {
+					mp4Filename = storage.Key(storage.KindMP4, safeCameraName, segment.TS)
+					err = s.store.Put(ctx, mp4Filename, mp4, "video/mp4")
 					if err != nil {
 						lgr.Error("s3_put_obj_err", "err", err)
 						continue
 					}
 				}
 
-				if s.conf.WebhookURL != "" {
-					var mp4PresignedURL, tiledPresignedURL string
-					if mp4Filename != "" {
-						mp4Req, _ := s3client.GetObjectRequest(&s3.GetObjectInput{
-							Bucket: &s.conf.Bucket,
-							Key:    &mp4Filename,
-						})
-
-						mp4PresignedURL, err = mp4Req.Presign(6 * time.Hour)
-						if err != nil {
-							lgr.Error("s3_presign_err", "err", err)
-						}
+				if tiled != nil {
+					tiledFilename = storage.Key(storage.KindTiled, safeCameraName, segment.TS)
+					err = s.store.Put(ctx, tiledFilename, tiled, "image/jpeg")
+					if err != nil {
+						lgr.Error("s3_put_obj_err", "err", err)
+						continue
 					}
+				}
 
-					if tiledFilename != "" {
-						tiledReq, _ := s3client.GetObjectRequest(&s3.GetObjectInput{
-							Bucket: &s.conf.Bucket,
-							Key:    &tiledFilename,
-						})
+				ev.MP4Key = mp4Filename
+				ev.TiledKey = tiledFilename
 
-						tiledPresignedURL, err = tiledReq.Presign(6 * time.Hour)
-						if err != nil {
-							lgr.Error("s3_presign_err", "err", err)
-						}
+				err = s.putEvent(ctx, ev)
+				if err != nil {
+					lgr.Error("s3_put_event_err", "err", err)
+				}
+
+				if mp4Filename != "" {
+					n.ClipURL, err = s.store.SignedURL(mp4Filename, 6*time.Hour)
+					if err != nil {
+						lgr.Error("s3_presign_err", "err", err)
 					}
+				}
 
-					err = slack.PostWebhook(s.conf.WebhookURL, &slack.WebhookMessage{
-						Attachments: []slack.Attachment{
-							{
-								Title:     fmt.Sprintf("%s %s", s.conf.Name, segment.TS.In(loc).Format(time.RFC3339)),
-								TitleLink: mp4PresignedURL,
-								ImageURL:  tiledPresignedURL,
-								Fields: []slack.AttachmentField{
-									{
-										Title: "Frames",
-										Value: strconv.Itoa(len(motionFrames)),
-										Short: true,
-									},
-								},
-							},
-						},
-					})
+				if tiledFilename != "" {
+					n.ImageURL, err = s.store.SignedURL(tiledFilename, 6*time.Hour)
 					if err != nil {
-						lgr.Error("slack_webhook_err", "err", err)
+						lgr.Error("s3_presign_err", "err", err)
 					}
 				}
 			}
+
+			s.addRecentEvent(ev)
+
+			if s.mqttClient != nil && bestFrameJPG != nil && armState.Mode.Publishes() {
+				s.mqttClient.PublishSnapshot(bestFrameJPG)
+			}
+
+			if !armState.Mode.Notifies() {
+				continue
+			}
+
+			n.Event = ev
+			n.TiledJPG = tiled
+			n.MP4 = mp4
+			n.BestFrameJPG = bestFrameJPG
+			for _, notifier := range s.notifiers {
+				err = notifier.Notify(ctx, &n)
+				if err != nil {
+					lgr.Error("notify_err", "notifier", notifier.Name(), "err", err)
+				}
+			}
 		}
 	}
 }
 
-func (s *server) watchForHomeDevices() {
-	if len(s.conf.DisableRecordingForIPs) < 1 {
+// setMotion publishes motion start/stop transitions.
+func (s *server) setMotion(motion bool) {
+	s.mu.Lock()
+	changed := motion != s.motion
+	s.motion = motion
+	s.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	s.feed.Publish(feed.TypeMotion, map[string]bool{"motion": motion})
+	if s.mqttClient != nil {
+		s.mqttClient.PublishMotion(motion)
+	}
+}
+
+func (s *server) Motion() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.motion
+}
+
+func (s *server) addRecentEvent(ev event.Event) {
+	s.feed.Publish(feed.TypeEvent, ev)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recentEvents = append([]event.Event{ev}, s.recentEvents...)
+	if len(s.recentEvents) > maxRecentEvents {
+		s.recentEvents = s.recentEvents[:maxRecentEvents]
+	}
+}
+
+// RecentEvents returns the events recorded since boot, newest first.
+func (s *server) RecentEvents() []event.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]event.Event(nil), s.recentEvents...)
+}
+
+// publishSegment announces the segment just added to the ring.
+func (s *server) publishSegment() {
+	win := s.ring.Window()
+	if len(win.Segments) == 0 {
+		return
+	}
+	seg := win.Segments[len(win.Segments)-1]
+	s.feed.Publish(feed.TypeSegment, feed.Segment{
+		ID:            seg.ID,
+		Seq:           seg.Seq,
+		TS:            seg.TS,
+		Duration:      seg.Duration.Seconds(),
+		Frames:        seg.Frames,
+		Bytes:         len(seg.Data),
+		Discontinuity: seg.Discontinuity,
+	})
+}
+
+// publishScore returns a hasMotion callback that publishes every frame's
+// score. Frames are spread evenly over the segment to estimate when they
+// were captured.
+func (s *server) publishScore(seg segment.Segment) func(motionFrame) {
+	frameDur := 100 * time.Millisecond
+	if seg.Frames > 0 {
+		frameDur = seg.Duration / time.Duration(seg.Frames)
+	}
+	return func(f motionFrame) {
+		s.feed.Publish(feed.TypeMotionScore, feed.MotionScore{
+			TS:        seg.TS.Add(time.Duration(f.Idx) * frameDur),
+			Frame:     f.Idx,
+			Diff:      f.Diff,
+			Threshold: motionDiffThreshold,
+			Zones:     f.Zones,
+		})
+	}
+}
+
+// recordDetection updates the detector stats after a segment is checked
+// for motion.
+func (s *server) recordDetection(d time.Duration, frames []motionFrame, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	st := &s.detector
+	if err != nil {
+		st.Errors++
+		metrics.MotionDetectErrors.Inc()
 		return
 	}
+	metrics.MotionDetectDuration.Observe(d.Seconds())
+	metrics.MotionFrames.Observe(float64(len(frames)))
+
+	st.Segments++
+	s.detectTime += d
+	st.LastCheck = &now
+	st.LastCheckSeconds = d.Seconds()
+	st.AvgCheckSeconds = s.detectTime.Seconds() / float64(st.Segments)
+	st.LastMotionFrames = len(frames)
+	st.LastMaxDiff = 0
+	for _, f := range frames {
+		if f.Diff > st.LastMaxDiff {
+			st.LastMaxDiff = f.Diff
+		}
+	}
+	if len(frames) > 1 {
+		st.MotionSegments++
+		st.LastMotion = &now
+	}
+}
+
+func (s *server) DetectorStats() webserver.DetectorStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.detector
+	st.Threshold = motionDiffThreshold
+	return st
+}
+
+// RecordNext records the next segment as an event regardless of motion.
+func (s *server) RecordNext() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordNext = true
+}
+
+func (s *server) takeRecordNext() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.recordNext
+	s.recordNext = false
+	return record
+}
+
+// ResetCapture restarts ffmpeg. A reset that is already pending covers
+// this one.
+func (s *server) ResetCapture() {
+	s.feed.Publish(feed.TypeCaptureReset, struct{}{})
+	if s.mqttClient != nil {
+		s.mqttClient.SetAvailable(false)
+	}
+	select {
+	case s.resetChan <- struct{}{}:
+	default:
+	}
+}
+
+// captured records that capture is producing segments.
+func (s *server) captured() {
+	s.mu.Lock()
+	s.lastSegment = time.Now()
+	s.mu.Unlock()
+	if s.mqttClient != nil {
+		s.mqttClient.SetAvailable(true)
+	}
+}
+
+// watchCapture marks the camera unavailable when segments stop arriving,
+// e.g. because ffmpeg exited or the device went away.
+func (s *server) watchCapture(ctx context.Context) {
+	ticker := time.NewTicker(segmentSize)
+	defer ticker.Stop()
+	start := time.Now()
+
 	for {
-		var home int32
-		for _, checkIP := range s.conf.DisableRecordingForIPs {
-			err := ping.Pinger(checkIP, 2)
-			if err == nil {
-				home = 1
-				break
-			}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
-		atomic.StoreInt32(&s.someoneIsHome, home)
-		time.Sleep(60 * time.Second)
+		s.mu.Lock()
+		last := s.lastSegment
+		s.mu.Unlock()
+		if last.IsZero() {
+			last = start
+		}
+		if time.Since(last) > captureStallTimeout && s.mqttClient != nil {
+			s.mqttClient.SetAvailable(false)
+		}
+	}
+}
+
+func (s *server) Armed() bool {
+	return s.arming.State().Mode != arming.Disarmed
+}
+
+// SetArmed overrides the arming mode from a chat or mqtt command. The
+// override lasts until d has passed, or until it is changed again if d is
+// zero.
+func (s *server) SetArmed(armed bool, d time.Duration) {
+	mode := arming.Disarmed
+	if armed {
+		mode = arming.ArmedNotify
 	}
+	s.arming.Override(mode, d, "command")
+}
+
+func (s *server) ArmingState() arming.State {
+	return s.arming.State()
 }
 
-func captureSource(ctx context.Context, lgr log15.Logger, resetChan chan struct{}, segmentChan chan segment.Segment) error {
+// Snapshot returns a jpg of the latest frame.
+func (s *server) Snapshot() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return s.mjpeg.Snapshot(ctx)
+}
+
+// recordContinuous reports if segments captured at ts should be stored
+// in continuous recording mode.
+func (s *server) recordContinuous(ts time.Time) bool {
+	if s.store == nil || !s.conf.Continuous.Enabled {
+		return false
+	}
+	return s.conf.Continuous.Schedule.Active(ts.In(loc))
+}
+
+// putEvent writes the event index record next to the footage so events
+// can be found on top of continuous recordings.
+func (s *server) putEvent(ctx context.Context, ev event.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(ctx, storage.Key(storage.KindEvent, ev.Camera, ev.TS), b, "application/json")
+}
+
+func (s *server) watchForHomeDevices(ctx context.Context, lgr log15.Logger, endpoint *presence.Endpoint) {
+	conf := s.conf.Presence
+	if !conf.Enabled() {
+		return
+	}
+
+	var providers []presence.Provider
+	if len(conf.PingIPs) > 0 {
+		providers = append(providers, presence.NewPing(conf.PingIPs))
+	}
+	if conf.ARP != nil {
+		providers = append(providers, presence.NewARP(conf.ARP.Path, conf.ARP.MACs, conf.ARP.IPs))
+	}
+	for _, d := range conf.DHCP {
+		providers = append(providers, presence.NewDHCP(d.Path, d.MACs, d.Hostnames))
+	}
+	for _, r := range conf.Router {
+		providers = append(providers, presence.NewRouter(r.URL, r.Username, r.Password, r.Headers, r.Match))
+	}
+	if endpoint != nil {
+		providers = append(providers, endpoint)
+	}
+
+	monitor := presence.NewMonitor(lgr, providers, conf.Interval, conf.AwayGrace, func(home bool) {
+		s.arming.SetHome(home)
+		s.feed.Publish(feed.TypePresence, map[string]bool{"home": home})
+		metrics.SetHome(home)
+		if s.mqttClient != nil {
+			s.mqttClient.PublishPresence(home)
+		}
+	})
+	monitor.Run(ctx)
+}
+
+func captureSource(ctx context.Context, lgr log15.Logger, resetChan chan struct{}, segmentChan chan segment.Segment, live *segment.Live) error {
 	firstResultChan := make(chan error)
 	go func() {
 		for {
 			childCtx, cancel := context.WithCancel(ctx)
-			err := captureSourceOnce(childCtx, lgr, segmentChan)
+			err := captureSourceOnce(childCtx, lgr, segmentChan, live)
 			select {
 			case firstResultChan <- err:
 			default:
@@ -292,6 +789,7 @@ func captureSource(ctx context.Context, lgr log15.Logger, resetChan chan struct{
 			select {
 			case <-resetChan:
 				lgr.Info("resetting_src_stream")
+				metrics.FFmpegRestarts.Inc()
 				cancel()
 			case <-ctx.Done():
 				cancel()
@@ -306,7 +804,9 @@ func captureSource(ctx context.Context, lgr log15.Logger, resetChan chan struct{
 	return firstResultErr
 }
 
-func captureSourceOnce(ctx context.Context, lgr log15.Logger, segmentChan chan segment.Segment) error {
+// captureSourceOnce runs ffmpeg and splits its output into segments. The
+// packets are also written to live as they arrive for low latency HLS.
+func captureSourceOnce(ctx context.Context, lgr log15.Logger, segmentChan chan segment.Segment, live *segment.Live) error {
 	cmd := cmd(ffmpegPath, "-f", "video4linux2", "-r", "10", "-input_format", "h264", "-video_size", "640x480", "-i", dev, "-vcodec", "copy", "-acodec", "copy", "-f", "mpegts", "-")
 
 	stderr := &bytes.Buffer{}
@@ -327,6 +827,9 @@ func captureSourceOnce(ctx context.Context, lgr log15.Logger, segmentChan chan s
 			segmentIdx    int
 		)
 
+		// the next capture starts a new stream
+		defer live.Discontinuity()
+
 		for {
 			allocedBuf := make([]byte, 0, pktlen*10*10) // 10fps * 10 seconds
 			w := bytes.NewBuffer(allocedBuf)
@@ -347,6 +850,7 @@ func captureSourceOnce(ctx context.Context, lgr log15.Logger, segmentChan chan s
 					} else {
 						hasPendingPPS = false
 						w.Write(pkt[:])
+						live.WritePacket(pkt[:], pkt.PayloadUnitStartIndicator())
 					}
 				}
 
@@ -383,14 +887,23 @@ func captureSourceOnce(ctx context.Context, lgr log15.Logger, segmentChan chan s
 				}
 
 				w.Write(pkt[:])
+				live.WritePacket(pkt[:], pkt.PayloadUnitStartIndicator())
 				count++
 			}
 
+			live.EndSegment()
+
 			segment := segment.Segment{
-				TS:     ts,
-				Idx:    segmentIdx,
-				Data:   w.Bytes(),
-				Frames: frameCount,
+				TS:       ts,
+				Idx:      segmentIdx,
+				Data:     w.Bytes(),
+				Frames:   frameCount,
+				Duration: time.Since(ts),
+			}
+			// prefer the media duration; the wall clock includes however
+			// long ffmpeg took to hand us the packets
+			if d, ok := tsdemux.Duration(segment.Data); ok {
+				segment.Duration = d
 			}
 			segmentIdx++
 
@@ -429,7 +942,13 @@ func captureSourceOnce(ctx context.Context, lgr log15.Logger, segmentChan chan s
 	return nil
 }
 
-func hasMotion(ctx context.Context, lgr log15.Logger, segment segment.Segment) ([]motionFrame, error) {
+// motionDiffThreshold is the edge intensity difference between two frames
+// (or within a zone) that counts as motion.
+const motionDiffThreshold = 20000
+
+// hasMotion returns the frames of segment with motion. onFrame, if set,
+// is called with the score of every frame as it is computed.
+func hasMotion(ctx context.Context, lgr log15.Logger, segment segment.Segment, zones []config.Zone, onFrame func(motionFrame)) ([]motionFrame, error) {
 	cmd := cmd(ffmpegPath, "-f", "mpegts", "-i", "-", "-vcodec", "rawvideo", "-pix_fmt", "gray", "-vf", "edgedetect", "-f", "rawvideo", "-")
 
 	var stderr bytes.Buffer
@@ -495,11 +1014,16 @@ func hasMotion(ctx context.Context, lgr log15.Logger, segment segment.Segment) (
 			diff = sumNext - sumPrev
 		}
 
-		if diff > 20000 {
-			motionFrames = append(motionFrames, motionFrame{
-				Idx:  i,
-				Diff: diff,
-			})
+		frame := motionFrame{
+			Idx:  i,
+			Diff: diff,
+		}
+		if diff > motionDiffThreshold {
+			frame.Zones = zonesWithMotion(zones, prev, next, width)
+			motionFrames = append(motionFrames, frame)
+		}
+		if onFrame != nil {
+			onFrame(frame)
 		}
 
 		copy(prev, next)
@@ -516,8 +1040,48 @@ func hasMotion(ctx context.Context, lgr log15.Logger, segment segment.Segment) (
 }
 
 type motionFrame struct {
-	Idx  int
-	Diff int
+	Idx   int
+	Diff  int
+	Zones []string
+}
+
+func zonesWithMotion(zones []config.Zone, prev, next []uint8, width int) []string {
+	var out []string
+	for _, z := range zones {
+		sumPrev := 0
+		sumNext := 0
+		for h := z.Y; h < z.Y+z.Height; h++ {
+			for w := z.X; w < z.X+z.Width; w++ {
+				idx := h*width + w
+				sumPrev += int(prev[idx])
+				sumNext += int(next[idx])
+			}
+		}
+
+		diff := sumPrev - sumNext
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > motionDiffThreshold {
+			out = append(out, z.Name)
+		}
+	}
+	return out
+}
+
+// motionZones returns every zone that saw motion in any frame.
+func motionZones(frames []motionFrame) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, f := range frames {
+		for _, z := range f.Zones {
+			if !seen[z] {
+				seen[z] = true
+				out = append(out, z)
+			}
+		}
+	}
+	return out
 }
 
 var hasMotionFFMPEGExitErr = errors.New("ffmpeg exit err")
@@ -639,7 +1203,7 @@ func toGIF(ctx context.Context, segment segment.Segment) ([]byte, error) {
 }
 
 func toStillJPG(ctx context.Context, segment segment.Segment, frame int) ([]byte, error) {
-	cmd := cmd(ffmpegPath, "-f", "mpegts", "-i", "-", "-f", "gif", "-")
+	cmd := cmd(ffmpegPath, "-f", "mpegts", "-i", "-", "-vf", fmt.Sprintf("select='eq(n,%d)'", frame), "-vframes", "1", "-f", "mjpeg", "-")
 	cmd.Stderr = io.Discard
 
 	buf := make([]byte, 0, len(segment.Data))
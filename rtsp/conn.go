@@ -0,0 +1,458 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/tsdemux"
+)
+
+const (
+	// sessionTimeout is advertised to clients, which send keepalives
+	// (usually GET_PARAMETER or OPTIONS, or RTCP receiver reports) more
+	// often than this. UDP sessions that go quiet for longer are closed;
+	// TCP sessions end when the connection does.
+	sessionTimeout = 60
+	writeTimeout   = 5 * time.Second
+	maxBodySize    = 64 << 10
+)
+
+var statusText = map[int]string{
+	200: "OK",
+	400: "Bad Request",
+	401: "Unauthorized",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	454: "Session Not Found",
+	455: "Method Not Valid in This State",
+	461: "Unsupported Transport",
+	501: "Not Implemented",
+	503: "Service Unavailable",
+}
+
+type request struct {
+	method string
+	url    string
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// response headers are kept in order and with their exact names since
+// some clients match names case sensitively.
+type response struct {
+	status int
+	header [][2]string
+	body   []byte
+	// after runs once the response is written.
+	after func()
+}
+
+func newResponse(status int) *response {
+	return &response{
+		status: status,
+	}
+}
+
+func (r *response) set(key, value string) {
+	r.header = append(r.header, [2]string{key, value})
+}
+
+// conn is one RTSP connection. It has at most one session since we only
+// have one track.
+type conn struct {
+	srv *Server
+	nc  net.Conn
+	br  *bufio.Reader
+	lgr log15.Logger
+
+	// wmu serializes responses and interleaved media.
+	wmu sync.Mutex
+
+	session *session
+}
+
+func (c *conn) serve() {
+	defer c.nc.Close()
+	defer func() {
+		if c.session != nil {
+			c.session.stop()
+			c.srv.unregisterUDP(c, c.session.transport)
+		}
+	}()
+
+	for {
+		if c.session != nil && !c.session.transport.tcp {
+			// extended by rtcp from the client, see Server.readUDP
+			c.touch()
+		} else {
+			c.nc.SetReadDeadline(time.Time{})
+		}
+
+		b, err := c.br.Peek(1)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.lgr.Info("rtsp_session_timeout", "session", c.session.id)
+			}
+			return
+		}
+		if b[0] == '$' {
+			// interleaved rtcp from the client; we don't use it
+			var hdr [4]byte
+			if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+				return
+			}
+			n := int(hdr[2])<<8 | int(hdr[3])
+			if _, err := c.br.Discard(n); err != nil {
+				return
+			}
+			continue
+		}
+
+		req, err := readRequest(c.br)
+		if err != nil {
+			if err != io.EOF {
+				c.lgr.Error("rtsp_read_request_err", "err", err)
+			}
+			return
+		}
+
+		resp := c.handle(req)
+		resp.header = append([][2]string{{"CSeq", req.header.Get("CSeq")}, {"Server", "rom-cam"}}, resp.header...)
+		if err := c.writeResponse(resp); err != nil {
+			return
+		}
+		if resp.after != nil {
+			resp.after()
+		}
+	}
+}
+
+// touch extends a UDP session's timeout.
+func (c *conn) touch() {
+	c.nc.SetReadDeadline(time.Now().Add(sessionTimeout * time.Second))
+}
+
+func readRequest(br *bufio.Reader) (*request, error) {
+	tp := textproto.NewReader(br)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 || !strings.HasPrefix(fields[2], "RTSP/") {
+		return nil, fmt.Errorf("malformed request line %q", line)
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request{
+		method: fields[0],
+		url:    fields[1],
+		header: header,
+	}
+
+	if cl := header.Get("Content-Length"); cl != "" {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 || n > maxBodySize {
+			return nil, fmt.Errorf("invalid content length %q", cl)
+		}
+		req.body = make([]byte, n)
+		if _, err := io.ReadFull(br, req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+func (c *conn) writeResponse(resp *response) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "RTSP/1.0 %d %s\r\n", resp.status, statusText[resp.status])
+	if len(resp.body) > 0 {
+		resp.set("Content-Length", strconv.Itoa(len(resp.body)))
+	}
+	for _, h := range resp.header {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	b.WriteString("\r\n")
+	b.Write(resp.body)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.nc.Write(b.Bytes())
+	return err
+}
+
+// writeInterleaved sends an RTP or RTCP packet on the RTSP connection.
+func (c *conn) writeInterleaved(channel int, pkt []byte) error {
+	buf := make([]byte, 4+len(pkt))
+	buf[0] = '$'
+	buf[1] = byte(channel)
+	buf[2] = byte(len(pkt) >> 8)
+	buf[3] = byte(len(pkt))
+	copy(buf[4:], pkt)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.nc.Write(buf)
+	return err
+}
+
+func (c *conn) handle(req *request) *response {
+	if req.method != "OPTIONS" && !c.authorized(req) {
+		resp := newResponse(401)
+		resp.set("WWW-Authenticate", `Basic realm="rom-cam"`)
+		return resp
+	}
+
+	if req.method != "OPTIONS" && req.method != "DESCRIBE" && req.method != "SETUP" {
+		if id := req.header.Get("Session"); id != "" {
+			id, _, _ = strings.Cut(id, ";")
+			if c.session == nil || c.session.id != id {
+				return newResponse(454)
+			}
+		}
+	}
+
+	switch req.method {
+	case "OPTIONS":
+		resp := newResponse(200)
+		resp.set("Public", "OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN, GET_PARAMETER, SET_PARAMETER")
+		return resp
+	case "DESCRIBE":
+		return c.describe(req)
+	case "SETUP":
+		return c.setup(req)
+	case "PLAY":
+		return c.play(req)
+	case "PAUSE":
+		if c.session == nil {
+			return newResponse(455)
+		}
+		c.session.stop()
+		return c.sessionResponse(200)
+	case "TEARDOWN":
+		if c.session != nil {
+			c.session.stop()
+			c.srv.unregisterUDP(c, c.session.transport)
+			c.session = nil
+		}
+		return newResponse(200)
+	case "GET_PARAMETER", "SET_PARAMETER":
+		// keepalives
+		if c.session != nil {
+			return c.sessionResponse(200)
+		}
+		return newResponse(200)
+	default:
+		return newResponse(501)
+	}
+}
+
+func (c *conn) authorized(req *request) bool {
+	conf := c.srv.conf
+	if conf.Username == "" && conf.Password == "" {
+		return true
+	}
+	auth := req.header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return false
+	}
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(conf.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(conf.Password)) == 1
+	return userOK && passOK
+}
+
+func (c *conn) sessionResponse(status int) *response {
+	resp := newResponse(status)
+	resp.set("Session", fmt.Sprintf("%s;timeout=%d", c.session.id, sessionTimeout))
+	return resp
+}
+
+// describe returns the SDP for our single H.264 track. The parameter
+// sets come from the segment currently being captured; clients that need
+// them up front get them here, and they are repeated in band before
+// every IDR frame.
+func (c *conn) describe(req *request) *response {
+	var sps, pps []byte
+	win := c.srv.live.Window()
+	for i := len(win.Segments) - 1; i >= 0 && sps == nil; i-- {
+		seg := win.Segments[i]
+		if len(seg.Parts) > 0 {
+			sps, pps = tsdemux.ParameterSets(seg.Parts[0].Data)
+		}
+	}
+
+	fmtp := "packetization-mode=1"
+	if len(sps) >= 4 {
+		fmtp += fmt.Sprintf(";profile-level-id=%02X%02X%02X", sps[1], sps[2], sps[3])
+	}
+	if sps != nil && pps != nil {
+		fmtp += ";sprop-parameter-sets=" + base64.StdEncoding.EncodeToString(sps) + "," + base64.StdEncoding.EncodeToString(pps)
+	}
+
+	host := "0.0.0.0"
+	if addr, ok := c.nc.LocalAddr().(*net.TCPAddr); ok && addr.IP.To4() != nil {
+		host = addr.IP.String()
+	}
+
+	var b bytes.Buffer
+	b.WriteString("v=0\r\n")
+	fmt.Fprintf(&b, "o=- %d 1 IN IP4 %s\r\n", time.Now().Unix(), host)
+	b.WriteString("s=rom-cam\r\n")
+	b.WriteString("c=IN IP4 0.0.0.0\r\n")
+	b.WriteString("t=0 0\r\n")
+	b.WriteString("a=control:*\r\n")
+	fmt.Fprintf(&b, "m=video 0 RTP/AVP %d\r\n", payloadType)
+	fmt.Fprintf(&b, "a=rtpmap:%d H264/90000\r\n", payloadType)
+	fmt.Fprintf(&b, "a=fmtp:%d %s\r\n", payloadType, fmtp)
+	fmt.Fprintf(&b, "a=control:%s\r\n", trackControl)
+
+	resp := newResponse(200)
+	resp.set("Content-Type", "application/sdp")
+	resp.set("Content-Base", strings.TrimSuffix(req.url, "/")+"/")
+	resp.body = b.Bytes()
+	return resp
+}
+
+var errUnsupportedTransport = errors.New("unsupported transport")
+
+// parseTransport picks the first transport from the client's list that we
+// support.
+func parseTransport(header string, remote net.Addr) (*transport, error) {
+	for _, spec := range strings.Split(header, ",") {
+		params := strings.Split(strings.TrimSpace(spec), ";")
+		t := &transport{}
+		switch strings.ToUpper(params[0]) {
+		case "RTP/AVP/TCP":
+			t.tcp = true
+			t.rtpChannel, t.rtcpChannel = 0, 1
+		case "RTP/AVP", "RTP/AVP/UDP":
+		default:
+			continue
+		}
+
+		ok := true
+		for _, p := range params[1:] {
+			key, val, _ := strings.Cut(p, "=")
+			switch strings.ToLower(key) {
+			case "multicast":
+				ok = false
+			case "interleaved":
+				a, b, err := parsePortPair(val)
+				if err != nil {
+					ok = false
+				}
+				t.rtpChannel, t.rtcpChannel = a, b
+			case "client_port":
+				a, b, err := parsePortPair(val)
+				if err != nil {
+					ok = false
+				}
+				t.clientRTP, t.clientRTCP = a, b
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		if !t.tcp {
+			tcpAddr, isTCP := remote.(*net.TCPAddr)
+			if t.clientRTP == 0 || !isTCP {
+				continue
+			}
+			t.rtpAddr = &net.UDPAddr{IP: tcpAddr.IP, Port: t.clientRTP}
+			t.rtcpAddr = &net.UDPAddr{IP: tcpAddr.IP, Port: t.clientRTCP}
+		}
+		return t, nil
+	}
+	return nil, errUnsupportedTransport
+}
+
+func parsePortPair(s string) (int, int, error) {
+	first, second, hasSecond := strings.Cut(s, "-")
+	a, err := strconv.Atoi(first)
+	if err != nil || a < 0 || a > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", s)
+	}
+	b := a + 1
+	if hasSecond {
+		b, err = strconv.Atoi(second)
+		if err != nil || b < 0 || b > 65535 {
+			return 0, 0, fmt.Errorf("invalid port %q", s)
+		}
+	}
+	return a, b, nil
+}
+
+func (c *conn) setup(req *request) *response {
+	t, err := parseTransport(req.header.Get("Transport"), c.nc.RemoteAddr())
+	if err != nil {
+		return newResponse(461)
+	}
+
+	if c.session != nil {
+		c.session.stop()
+		c.srv.unregisterUDP(c, c.session.transport)
+	}
+	c.session = newSession(c, t)
+	c.srv.registerUDP(c, t)
+
+	resp := c.sessionResponse(200)
+	if t.tcp {
+		resp.set("Transport", fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d;ssrc=%08X", t.rtpChannel, t.rtcpChannel, c.session.ssrc))
+	} else {
+		port := c.srv.conf.RTPPort
+		resp.set("Transport", fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d;ssrc=%08X", t.clientRTP, t.clientRTCP, port, port+1, c.session.ssrc))
+	}
+	return resp
+}
+
+func (c *conn) play(req *request) *response {
+	sess := c.session
+	if sess == nil {
+		return newResponse(455)
+	}
+
+	trackURL := strings.TrimSuffix(req.url, "/")
+	if !strings.HasSuffix(trackURL, "/"+trackControl) {
+		trackURL += "/" + trackControl
+	}
+
+	resp := c.sessionResponse(200)
+	resp.set("Range", "npt=0.000-")
+	if !sess.playing() {
+		c.lgr.Info("rtsp_play", "session", sess.id, "tcp", sess.transport.tcp)
+		seq, rtptime := sess.next()
+		resp.set("RTP-Info", fmt.Sprintf("url=%s;seq=%d;rtptime=%d", trackURL, seq, rtptime))
+		// clients expect the response before any media
+		resp.after = sess.start
+	}
+	return resp
+}
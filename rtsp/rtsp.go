@@ -0,0 +1,155 @@
+// Package rtsp serves the live stream over RTSP so the camera can be
+// added to NVRs like Frigate and Blue Iris or watched in VLC. There is a
+// single H.264 track, sent as RTP either interleaved on the RTSP
+// connection or over UDP.
+package rtsp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/segment"
+)
+
+// Server accepts RTSP connections. Every client gets its own
+// subscription to the live stream.
+type Server struct {
+	lgr  log15.Logger
+	live *segment.Live
+	conf config.RTSP
+
+	ln       net.Listener
+	rtpConn  *net.UDPConn
+	rtcpConn *net.UDPConn
+
+	mu      sync.Mutex
+	clients map[*conn]struct{}
+	// udpClients maps the addresses of UDP sessions to their connection.
+	udpClients map[string]*conn
+}
+
+// NewServer listens on conf.ListenAddr for RTSP and on conf.RTPPort and
+// the port after it for UDP media.
+func NewServer(lgr log15.Logger, live *segment.Live, conf config.RTSP) (*Server, error) {
+	ln, err := net.Listen("tcp", conf.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: conf.RTPPort})
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("listen rtp port: %w", err)
+	}
+	rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: conf.RTPPort + 1})
+	if err != nil {
+		ln.Close()
+		rtpConn.Close()
+		return nil, fmt.Errorf("listen rtcp port: %w", err)
+	}
+
+	return &Server{
+		lgr:      lgr,
+		live:     live,
+		conf:     conf,
+		ln:       ln,
+		rtpConn:  rtpConn,
+		rtcpConn: rtcpConn,
+		clients:  make(map[*conn]struct{}),
+
+		udpClients: make(map[string]*conn),
+	}, nil
+}
+
+// Run accepts connections until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.ln.Close()
+		s.rtpConn.Close()
+		s.rtcpConn.Close()
+
+		s.mu.Lock()
+		for c := range s.clients {
+			c.nc.Close()
+		}
+		s.mu.Unlock()
+	}()
+
+	go s.readUDP(s.rtpConn)
+	go s.readUDP(s.rtcpConn)
+
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		c := &conn{
+			srv: s,
+			nc:  nc,
+			br:  bufio.NewReader(nc),
+			lgr: s.lgr.New("remote", nc.RemoteAddr().String()),
+		}
+		s.mu.Lock()
+		s.clients[c] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			c.serve()
+			s.mu.Lock()
+			delete(s.clients, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// readUDP reads what clients send to the media ports, mostly RTCP
+// receiver reports. We don't use the contents, but they show a UDP client
+// is still there.
+func (s *Server) readUDP(uc *net.UDPConn) {
+	buf := make([]byte, 2048)
+	for {
+		_, addr, err := uc.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		c := s.udpClients[addr.String()]
+		s.mu.Unlock()
+		if c != nil {
+			c.touch()
+		}
+	}
+}
+
+func (s *Server) registerUDP(c *conn, t *transport) {
+	if t.tcp {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.udpClients[t.rtpAddr.String()] = c
+	s.udpClients[t.rtcpAddr.String()] = c
+}
+
+func (s *Server) unregisterUDP(c *conn, t *transport) {
+	if t.tcp {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, addr := range []*net.UDPAddr{t.rtpAddr, t.rtcpAddr} {
+		if s.udpClients[addr.String()] == c {
+			delete(s.udpClients, addr.String())
+		}
+	}
+}
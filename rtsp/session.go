@@ -0,0 +1,246 @@
+package rtsp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/psanford/rom-cam/tsdemux"
+)
+
+const (
+	payloadType  = 96
+	trackControl = "trackID=0"
+	// rtpMTU keeps UDP packets under a typical path MTU.
+	rtpMTU = 1400
+	// senderReportInterval is how often RTCP sender reports are sent so
+	// clients can map RTP time to wall clock time.
+	senderReportInterval = 5 * time.Second
+)
+
+type transport struct {
+	tcp bool
+	// rtpChannel and rtcpChannel are the interleaved channels for tcp.
+	rtpChannel  int
+	rtcpChannel int
+	// clientRTP and clientRTCP are the client's ports for udp.
+	clientRTP  int
+	clientRTCP int
+	rtpAddr    *net.UDPAddr
+	rtcpAddr   *net.UDPAddr
+}
+
+// session streams the live H.264 track to one client while playing.
+type session struct {
+	c         *conn
+	id        string
+	ssrc      uint32
+	transport *transport
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// owned by the stream goroutine while playing
+	seq       uint16
+	clock     tsdemux.RTPClock
+	payloader codecs.H264Payloader
+	packets   uint32
+	octets    uint32
+	lastTS    uint32
+	lastSent  time.Time
+}
+
+func newSession(c *conn, t *transport) *session {
+	var b [14]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return &session{
+		c:         c,
+		id:        hex.EncodeToString(b[:8]),
+		ssrc:      binary.BigEndian.Uint32(b[8:12]),
+		seq:       binary.BigEndian.Uint16(b[12:14]),
+		transport: t,
+	}
+}
+
+func (s *session) playing() bool {
+	return s.cancel != nil
+}
+
+// next returns the sequence number and RTP timestamp of the first packet
+// start will send. It is only valid while the session isn't playing.
+func (s *session) next() (uint16, uint32) {
+	return s.seq, s.clock.CatchUpStart()
+}
+
+// start begins streaming if the session isn't already playing.
+func (s *session) start() {
+	if s.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.stream(ctx)
+}
+
+// stop stops streaming and waits for the stream goroutine to exit.
+func (s *session) stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+}
+
+// stream sends the live stream from the start of the current segment,
+// which begins with an IDR frame. That backlog is sent immediately with
+// compressed timestamps so the client catches up to live.
+func (s *session) stream(ctx context.Context) {
+	defer close(s.done)
+
+	packets, unsubscribe := s.c.srv.live.Subscribe(4096)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(senderReportInterval)
+	defer ticker.Stop()
+
+	demux := tsdemux.NewDemuxer()
+	var (
+		err      error
+		catchUp  = true
+		haveIDR  bool
+		sendUnit = func(au tsdemux.AccessUnit) {
+			if err != nil {
+				return
+			}
+			if !haveIDR && !au.IDR {
+				return
+			}
+			haveIDR = true
+			err = s.writeAccessUnit(au, catchUp)
+			if catchUp && !s.transport.tcp {
+				// don't overrun socket buffers with the backlog
+				time.Sleep(time.Millisecond)
+			}
+		}
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err = s.writeSenderReport()
+		case pkt, ok := <-packets:
+			if !ok {
+				return
+			}
+			if len(pkt) == 0 {
+				// we fell behind and skipped to the next segment
+				demux.Reset()
+				haveIDR = false
+				continue
+			}
+			demux.Write(pkt, sendUnit)
+			catchUp = false
+		}
+
+		if err != nil {
+			if ctx.Err() == nil {
+				s.c.lgr.Error("rtsp_stream_err", "session", s.id, "err", err)
+				// the client is gone or stuck; drop the connection
+				s.c.nc.Close()
+			}
+			return
+		}
+	}
+}
+
+func (s *session) writeAccessUnit(au tsdemux.AccessUnit, catchUp bool) error {
+	ts := s.clock.Next(au.PTS, catchUp)
+
+	payloads := s.payloader.Payload(rtpMTU, au.Data)
+	for i, payload := range payloads {
+		pkt := rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i == len(payloads)-1,
+				PayloadType:    payloadType,
+				SequenceNumber: s.seq,
+				Timestamp:      ts,
+				SSRC:           s.ssrc,
+			},
+			Payload: payload,
+		}
+		s.seq++
+
+		raw, err := pkt.Marshal()
+		if err != nil {
+			return err
+		}
+		if err := s.write(raw, false); err != nil {
+			return err
+		}
+		s.packets++
+		s.octets += uint32(len(payload))
+	}
+
+	s.lastTS = ts
+	s.lastSent = time.Now()
+	return nil
+}
+
+func (s *session) writeSenderReport() error {
+	if s.lastSent.IsZero() {
+		return nil
+	}
+	now := time.Now()
+	sr := rtcp.SenderReport{
+		SSRC:        s.ssrc,
+		NTPTime:     ntpTime(now),
+		RTPTime:     s.lastTS + uint32(now.Sub(s.lastSent).Seconds()*90000),
+		PacketCount: s.packets,
+		OctetCount:  s.octets,
+	}
+	raw, err := sr.Marshal()
+	if err != nil {
+		return err
+	}
+	return s.write(raw, true)
+}
+
+func (s *session) write(pkt []byte, isRTCP bool) error {
+	t := s.transport
+	if t.tcp {
+		channel := t.rtpChannel
+		if isRTCP {
+			channel = t.rtcpChannel
+		}
+		return s.c.writeInterleaved(channel, pkt)
+	}
+
+	if isRTCP {
+		_, err := s.c.srv.rtcpConn.WriteToUDP(pkt, t.rtcpAddr)
+		return err
+	}
+	_, err := s.c.srv.rtpConn.WriteToUDP(pkt, t.rtpAddr)
+	return err
+}
+
+// ntpTime converts t to the 64 bit NTP timestamp format used in sender
+// reports.
+func ntpTime(t time.Time) uint64 {
+	const ntpEpochOffset = 2208988800
+	secs := uint64(t.Unix()) + ntpEpochOffset
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
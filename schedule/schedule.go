@@ -0,0 +1,118 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a recurring weekly time range. If End is before Start the
+// window runs past midnight into the following day. If Start and End are
+// equal the window covers the whole day.
+type Window struct {
+	// Days the window starts on, e.g. ["mon", "tue"]. Empty means every day.
+	Days  []string `toml:"days"`
+	Start string   `toml:"start"` // "HH:MM"
+	End   string   `toml:"end"`   // "HH:MM"
+}
+
+// Schedule is a set of windows. An empty schedule is always active.
+type Schedule []Window
+
+func (s Schedule) Validate() error {
+	for _, w := range s {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Schedule) Active(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, w := range s {
+		if w.Active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w Window) Validate() error {
+	if _, err := parseClock(w.Start); err != nil {
+		return fmt.Errorf("schedule start: %w", err)
+	}
+	if _, err := parseClock(w.End); err != nil {
+		return fmt.Errorf("schedule end: %w", err)
+	}
+	for _, d := range w.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("schedule: unknown day %q", d)
+		}
+	}
+	return nil
+}
+
+// Active reports if t falls within the window. t should already be in
+// the local time zone of the camera.
+func (w Window) Active(t time.Time) bool {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return false
+	}
+
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if start == end {
+		return w.onDay(t.Weekday())
+	}
+
+	if start < end {
+		return w.onDay(t.Weekday()) && now >= start && now < end
+	}
+
+	// window wraps midnight
+	if now >= start {
+		return w.onDay(t.Weekday())
+	}
+	if now < end {
+		return w.onDay((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (w Window) onDay(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if weekdays[strings.ToLower(name)] == d {
+			return true
+		}
+	}
+	return false
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,87 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowActive(t *testing.T) {
+	// 2023-01-02 is a monday
+	at := func(day int, clock string) time.Time {
+		c, err := time.Parse("15:04:05", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2023, 1, day, c.Hour(), c.Minute(), c.Second(), 0, time.UTC)
+	}
+	const mon, tue, wed, sat, sun = 2, 3, 4, 7, 8
+
+	tests := []struct {
+		name   string
+		window Window
+		t      time.Time
+		want   bool
+	}{
+		{"day start", Window{Start: "09:00", End: "17:00"}, at(mon, "09:00:00"), true},
+		{"day before start", Window{Start: "09:00", End: "17:00"}, at(mon, "08:59:59"), false},
+		{"day before end", Window{Start: "09:00", End: "17:00"}, at(mon, "16:59:59"), true},
+		{"day end is exclusive", Window{Start: "09:00", End: "17:00"}, at(mon, "17:00:00"), false},
+
+		{"wrap evening", Window{Start: "22:00", End: "06:00"}, at(mon, "23:30:00"), true},
+		{"wrap start", Window{Start: "22:00", End: "06:00"}, at(mon, "22:00:00"), true},
+		{"wrap midnight", Window{Start: "22:00", End: "06:00"}, at(tue, "00:00:00"), true},
+		{"wrap morning", Window{Start: "22:00", End: "06:00"}, at(tue, "05:59:59"), true},
+		{"wrap end is exclusive", Window{Start: "22:00", End: "06:00"}, at(tue, "06:00:00"), false},
+		{"wrap afternoon", Window{Start: "22:00", End: "06:00"}, at(tue, "12:00:00"), false},
+
+		// days are the days the window starts on, so friday night runs
+		// into saturday morning but saturday night doesn't
+		{"wrap day evening", Window{Days: []string{"Sat"}, Start: "22:00", End: "06:00"}, at(sat, "23:00:00"), true},
+		{"wrap day next morning", Window{Days: []string{"sat"}, Start: "22:00", End: "06:00"}, at(sun, "05:00:00"), true},
+		{"wrap day same morning", Window{Days: []string{"sat"}, Start: "22:00", End: "06:00"}, at(sat, "05:00:00"), false},
+		{"wrap day other evening", Window{Days: []string{"sat"}, Start: "22:00", End: "06:00"}, at(sun, "23:00:00"), false},
+		{"wrap sunday into monday", Window{Days: []string{"sun"}, Start: "22:00", End: "06:00"}, at(mon, "01:00:00"), true},
+		{"wrap sunday not into tuesday", Window{Days: []string{"sun"}, Start: "22:00", End: "06:00"}, at(tue, "01:00:00"), false},
+
+		{"days match", Window{Days: []string{"mon", "wed"}, Start: "09:00", End: "17:00"}, at(wed, "12:00:00"), true},
+		{"days don't match", Window{Days: []string{"mon", "wed"}, Start: "09:00", End: "17:00"}, at(tue, "12:00:00"), false},
+
+		{"whole day", Window{Days: []string{"tue"}, Start: "00:00", End: "00:00"}, at(tue, "23:59:59"), true},
+		{"whole day other day", Window{Days: []string{"tue"}, Start: "00:00", End: "00:00"}, at(wed, "00:00:00"), false},
+
+		{"invalid clock", Window{Start: "9am", End: "17:00"}, at(mon, "12:00:00"), false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.window.Active(tt.t); got != tt.want {
+			t.Errorf("%s: Active(%s) = %t, want %t", tt.name, tt.t.Format("Mon 15:04:05"), got, tt.want)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	noon := time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)
+	if !Schedule(nil).Active(noon) {
+		t.Error("empty schedule is not always active")
+	}
+	s := Schedule{{Start: "22:00", End: "06:00"}, {Start: "11:00", End: "13:00"}}
+	if !s.Active(noon) {
+		t.Error("second window not active")
+	}
+	if s.Active(noon.Add(2 * time.Hour)) {
+		t.Error("active outside both windows")
+	}
+
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+	for _, w := range []Window{
+		{Start: "25:00", End: "06:00"},
+		{Start: "22:00", End: "6"},
+		{Days: []string{"monday"}, Start: "22:00", End: "06:00"},
+	} {
+		if err := (Schedule{w}).Validate(); err == nil {
+			t.Errorf("%+v validated", w)
+		}
+	}
+}
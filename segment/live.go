@@ -0,0 +1,328 @@
+package segment
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Part is a partial segment for low latency HLS.
+type Part struct {
+	Data     []byte
+	Duration time.Duration
+	// Independent is set if the part starts with an IDR frame.
+	Independent bool
+}
+
+// LiveSegment is a segment that is being split into parts as it is
+// captured.
+type LiveSegment struct {
+	// MSN is the media sequence number. It increases across capture
+	// restarts.
+	MSN   int
+	TS    time.Time
+	Parts []Part
+	// Duration is the sum of the part durations.
+	Duration time.Duration
+	// Discontinuity is set on the first segment after a capture restart.
+	Discontinuity bool
+	Complete      bool
+}
+
+// Data returns the segment's parts joined together.
+func (s *LiveSegment) Data() []byte {
+	var n int
+	for _, p := range s.Parts {
+		n += len(p.Data)
+	}
+	out := make([]byte, 0, n)
+	for _, p := range s.Parts {
+		out = append(out, p.Data...)
+	}
+	return out
+}
+
+// Live splits the segment currently being captured into parts as packets
+// arrive and keeps the last few completed segments, so a low latency HLS
+// playlist can be served alongside the ring.
+type Live struct {
+	partTarget time.Duration
+	keep       int
+
+	mu       sync.Mutex
+	segments []*LiveSegment
+	current  *LiveSegment
+	nextMSN  int
+	// discontinuitySeq counts discontinuities in segments that have been
+	// dropped from the window.
+	discontinuitySeq int
+	pendingDisc      bool
+	partStart        time.Time
+	partBuf          []byte
+	// changed is closed and replaced whenever a part is added.
+	changed chan struct{}
+
+	subscribers map[chan []byte]*subscriber
+}
+
+type subscriber struct {
+	// lagging is set when the subscriber's channel filled up. It gets
+	// nothing more until the next segment starts.
+	lagging bool
+}
+
+var ErrLiveTimeout = errors.New("timeout waiting for part")
+
+// NewLive creates a Live that cuts parts of about partTarget and keeps
+// keep completed segments.
+func NewLive(partTarget time.Duration, keep int) *Live {
+	return &Live{
+		partTarget:  partTarget,
+		keep:        keep,
+		changed:     make(chan struct{}),
+		subscribers: make(map[chan []byte]*subscriber),
+	}
+}
+
+func (l *Live) PartTarget() time.Duration {
+	return l.partTarget
+}
+
+// WritePacket adds a mpegts packet to the current segment. unitStart is
+// the packet's payload unit start indicator; parts are only cut before
+// such packets so each part starts on a PES boundary.
+func (l *Live) WritePacket(pkt []byte, unitStart bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	segmentStart := l.current == nil
+	if segmentStart {
+		l.current = &LiveSegment{
+			MSN:           l.nextMSN,
+			TS:            now,
+			Discontinuity: l.pendingDisc,
+		}
+		l.nextMSN++
+		l.pendingDisc = false
+		l.partStart = now
+	}
+
+	// cut a little early so parts stay under the advertised target
+	if unitStart && len(l.partBuf) > 0 && now.Sub(l.partStart) >= l.partTarget*85/100 {
+		l.flushPart(now)
+	}
+
+	l.partBuf = append(l.partBuf, pkt...)
+
+	if len(l.subscribers) > 0 {
+		// subscribers share this copy, pkt is reused by the caller
+		out := append([]byte(nil), pkt...)
+		for ch, sub := range l.subscribers {
+			if sub.lagging {
+				// resume on a segment boundary, which starts with an IDR
+				// frame, after a gap marker
+				if !segmentStart || cap(ch)-len(ch) < 2 {
+					continue
+				}
+				ch <- nil
+				sub.lagging = false
+			}
+			select {
+			case ch <- out:
+			default:
+				// dropping single packets would corrupt the stream
+				// until the next IDR frame, so skip to the next segment
+				sub.lagging = true
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel that receives the raw mpegts stream. The
+// first read is everything captured so far for the current segment, so a
+// decoder can start from its IDR frame, followed by each packet as it is
+// written. If the subscriber falls behind it misses everything up to the
+// start of the next segment; an empty read marks the gap so decoders can
+// discard partial state. The returned func unsubscribes and closes the
+// channel.
+func (l *Live) Subscribe(buffer int) (<-chan []byte, func()) {
+	ch, _, unsubscribe := l.SubscribeBacklog(buffer)
+	return ch, unsubscribe
+}
+
+// SubscribeBacklog is Subscribe but also returns how long ago the current
+// segment, which is sent as the first read, started.
+func (l *Live) SubscribeBacklog(buffer int) (<-chan []byte, time.Duration, func()) {
+	ch := make(chan []byte, buffer+1)
+
+	var backlog time.Duration
+	l.mu.Lock()
+	if l.current != nil {
+		backlog = time.Since(l.current.TS)
+		var start []byte
+		for _, p := range l.current.Parts {
+			start = append(start, p.Data...)
+		}
+		start = append(start, l.partBuf...)
+		ch <- start
+	}
+	l.subscribers[ch] = &subscriber{}
+	l.mu.Unlock()
+
+	var once sync.Once
+	return ch, backlog, func() {
+		once.Do(func() {
+			l.mu.Lock()
+			delete(l.subscribers, ch)
+			l.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (l *Live) flushPart(now time.Time) {
+	p := Part{
+		Data:        l.partBuf,
+		Duration:    now.Sub(l.partStart),
+		Independent: len(l.current.Parts) == 0,
+	}
+	l.current.Parts = append(l.current.Parts, p)
+	l.current.Duration += p.Duration
+	l.partBuf = nil
+	l.partStart = now
+	l.broadcast()
+}
+
+// EndSegment completes the current segment.
+func (l *Live) EndSegment() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.current == nil {
+		return
+	}
+	if len(l.partBuf) > 0 {
+		l.flushPart(time.Now())
+	}
+
+	l.current.Complete = true
+	l.segments = append(l.segments, l.current)
+	l.current = nil
+
+	for len(l.segments) > l.keep {
+		if l.segments[0].Discontinuity {
+			l.discontinuitySeq++
+		}
+		l.segments[0] = nil
+		l.segments = l.segments[1:]
+	}
+	l.broadcast()
+}
+
+// Discontinuity completes the current segment and marks the next one as
+// following a capture restart.
+func (l *Live) Discontinuity() {
+	l.EndSegment()
+	l.mu.Lock()
+	l.pendingDisc = true
+	l.mu.Unlock()
+}
+
+func (l *Live) broadcast() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// LiveWindow is a snapshot of the segments in a Live. Part data is shared
+// with the Live and must not be modified.
+type LiveWindow struct {
+	Segments []LiveSegment
+	// DiscontinuitySeq is the discontinuity sequence number of the first
+	// segment.
+	DiscontinuitySeq int
+	// NextMSN and NextPart identify the next part to be published.
+	NextMSN  int
+	NextPart int
+}
+
+func (l *Live) Window() LiveWindow {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.window()
+}
+
+func (l *Live) window() LiveWindow {
+	w := LiveWindow{
+		DiscontinuitySeq: l.discontinuitySeq,
+		NextMSN:          l.nextMSN,
+	}
+	for _, s := range l.segments {
+		w.Segments = append(w.Segments, *s)
+	}
+	if l.current != nil {
+		cur := *l.current
+		cur.Parts = cur.Parts[:len(cur.Parts):len(cur.Parts)]
+		w.Segments = append(w.Segments, cur)
+		w.NextMSN = cur.MSN
+		w.NextPart = len(cur.Parts)
+	}
+	return w
+}
+
+// has reports if part of segment msn has been published. A negative
+// part means the whole segment.
+func (l *Live) has(msn, part int) bool {
+	for _, s := range l.segments {
+		if s.MSN >= msn {
+			return true
+		}
+	}
+	if l.current == nil || l.current.MSN < msn {
+		return false
+	}
+	if l.current.MSN > msn {
+		return true
+	}
+	return part >= 0 && len(l.current.Parts) > part
+}
+
+// Wait blocks until part of segment msn is published, or the whole
+// segment if part is negative, then returns the window.
+func (l *Live) Wait(ctx context.Context, msn, part int, timeout time.Duration) (LiveWindow, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		l.mu.Lock()
+		if l.has(msn, part) {
+			w := l.window()
+			l.mu.Unlock()
+			return w, nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return LiveWindow{}, ctx.Err()
+		case <-timer.C:
+			return LiveWindow{}, ErrLiveTimeout
+		case <-changed:
+		}
+	}
+}
+
+// Find returns the segment with the given media sequence number from the
+// window.
+func (w LiveWindow) Find(msn int) (LiveSegment, bool) {
+	if len(w.Segments) == 0 {
+		return LiveSegment{}, false
+	}
+	i := msn - w.Segments[0].MSN
+	if i < 0 || i >= len(w.Segments) {
+		return LiveSegment{}, false
+	}
+	return w.Segments[i], true
+}
@@ -1,53 +1,188 @@
 package segment
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 type Segment struct {
-	TS     time.Time
-	Idx    int
-	Data   []byte
-	Frames int
+	TS time.Time
+	// Idx counts segments since the capture started. It resets to 0 when
+	// the capture restarts.
+	Idx      int
+	Data     []byte
+	Frames   int
+	Duration time.Duration
+
+	// Seq is the media sequence number, assigned by the ring. Unlike Idx
+	// it keeps increasing across capture restarts.
+	Seq int
+	// ID is assigned by the ring and is unique across daemon restarts, so
+	// it is safe to use in cacheable urls.
+	ID string
+	// Discontinuity is set by the ring on the first segment after a
+	// capture restart.
+	Discontinuity bool
 }
 
+// Ring holds the most recent segments, bounded by duration and memory.
+// The ring keeps the fewest segments that cover maxDuration, and never
+// more than maxBytes except that the newest segment is always kept.
 type Ring struct {
+	maxDuration time.Duration
+	maxBytes    int64
+	// epoch prefixes segment IDs so they don't repeat across restarts.
+	epoch string
+
+	mu sync.Mutex
+	// segments is replaced rather than modified on Push so readers can
+	// share it without copying. Segments are ordered by Seq with no gaps.
 	segments []Segment
-	oldest   int
-	max      int
-	mu       sync.Mutex
+	duration time.Duration
+	bytes    int64
+	nextSeq  int
+	lastIdx  int
+	// discontinuitySeq counts discontinuities in segments that have been
+	// dropped from the ring.
+	discontinuitySeq int
 }
 
-func NewRing(max int) *Ring {
+// RingStats reports the ring's current and maximum size.
+type RingStats struct {
+	Segments    int           `json:"segments"`
+	Bytes       int64         `json:"bytes"`
+	Duration    time.Duration `json:"duration"`
+	MaxBytes    int64         `json:"max_bytes,omitempty"`
+	MaxDuration time.Duration `json:"max_duration,omitempty"`
+}
+
+// NewRing creates a ring that keeps up to maxDuration of video using at
+// most maxBytes of memory. A zero limit is not enforced.
+func NewRing(maxDuration time.Duration, maxBytes int64) *Ring {
 	return &Ring{
-		segments: make([]Segment, 0, max),
-		oldest:   0,
-		max:      max,
+		maxDuration: maxDuration,
+		maxBytes:    maxBytes,
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		lastIdx:     -1,
 	}
 }
 
 func (r *Ring) Push(s Segment) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if len(r.segments) < r.max {
-		r.segments = append(r.segments, s)
-		return
+
+	s.Seq = r.nextSeq
+	s.ID = fmt.Sprintf("%s-%d", r.epoch, s.Seq)
+	r.nextSeq++
+	s.Discontinuity = s.Idx <= r.lastIdx
+	r.lastIdx = s.Idx
+
+	r.duration += s.Duration
+	r.bytes += segmentBytes(s)
+
+	drop := 0
+	for drop < len(r.segments) && r.overLimit(r.segments[drop]) {
+		r.duration -= r.segments[drop].Duration
+		r.bytes -= segmentBytes(r.segments[drop])
+		if r.segments[drop].Discontinuity {
+			r.discontinuitySeq++
+		}
+		drop++
 	}
 
-	r.segments[r.oldest] = s
-	r.oldest = (r.oldest + 1) % r.max
+	segments := make([]Segment, 0, len(r.segments)-drop+1)
+	segments = append(segments, r.segments[drop:]...)
+	r.segments = append(segments, s)
 }
 
-func (r *Ring) Segments() []Segment {
+// overLimit reports if the oldest segment should be dropped.
+func (r *Ring) overLimit(oldest Segment) bool {
+	if r.maxDuration > 0 && r.duration-oldest.Duration >= r.maxDuration {
+		return true
+	}
+	if r.maxBytes > 0 && r.bytes > r.maxBytes {
+		return true
+	}
+	return false
+}
+
+// segmentBytes is the memory held by a segment's data, which may be more
+// than its length.
+func segmentBytes(s Segment) int64 {
+	return int64(cap(s.Data))
+}
+
+// Get returns the segment with the given ID.
+func (r *Ring) Get(id string) (Segment, bool) {
+	epoch, seqStr, ok := strings.Cut(id, "-")
+	if !ok || epoch != r.epoch {
+		return Segment{}, false
+	}
+	seq, err := strconv.Atoi(seqStr)
+	if err != nil {
+		return Segment{}, false
+	}
+
+	r.mu.Lock()
+	segments := r.segments
+	r.mu.Unlock()
+
+	if len(segments) == 0 {
+		return Segment{}, false
+	}
+	i := seq - segments[0].Seq
+	if i < 0 || i >= len(segments) {
+		return Segment{}, false
+	}
+	return segments[i], true
+}
+
+// RingWindow is a snapshot of the segments in a Ring. Segments is shared
+// with the Ring and must not be modified.
+type RingWindow struct {
+	Segments []Segment
+	// DiscontinuitySeq is the discontinuity sequence number of the first
+	// segment.
+	DiscontinuitySeq int
+}
+
+func (r *Ring) Window() RingWindow {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	out := make([]Segment, 0, len(r.segments))
-	for i := 0; i < len(r.segments); i++ {
-		idx := (i + r.oldest) % r.max
-		out = append(out, r.segments[idx])
+	return RingWindow{
+		Segments:         r.segments,
+		DiscontinuitySeq: r.discontinuitySeq,
+	}
+}
+
+// Tail returns the window's last n segments.
+func (w RingWindow) Tail(n int) RingWindow {
+	if n >= len(w.Segments) {
+		return w
 	}
+	drop := len(w.Segments) - n
+	for _, s := range w.Segments[:drop] {
+		if s.Discontinuity {
+			w.DiscontinuitySeq++
+		}
+	}
+	w.Segments = w.Segments[drop:]
+	return w
+}
 
-	return out
+func (r *Ring) Stats() RingStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return RingStats{
+		Segments:    len(r.segments),
+		Bytes:       r.bytes,
+		Duration:    r.duration,
+		MaxBytes:    r.maxBytes,
+		MaxDuration: r.maxDuration,
+	}
 }
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of objects stored for each segment. Keys are laid out as
+// <kind>/<camera>/<unix ts>.<ext>.
+const (
+	KindTS         = "ts"
+	KindMP4        = "mp4"
+	KindTiled      = "tiled"
+	KindEvent      = "events"
+	KindContinuous = "continuous"
+)
+
+var kindExt = map[string]string{
+	KindTS:         "ts",
+	KindMP4:        "mp4",
+	KindTiled:      "jpg",
+	KindEvent:      "json",
+	KindContinuous: "ts",
+}
+
+// IsVideo reports if objects of this kind hold video data.
+func IsVideo(kind string) bool {
+	return kind == KindTS || kind == KindMP4 || kind == KindContinuous
+}
+
+func Key(kind, camera string, ts time.Time) string {
+	return fmt.Sprintf("%s/%s/%d.%s", kind, camera, ts.Unix(), kindExt[kind])
+}
+
+func Prefix(kind, camera string) string {
+	return fmt.Sprintf("%s/%s/", kind, camera)
+}
+
+// ParseKey splits a key created by Key back into its parts.
+func ParseKey(key string) (kind, camera string, ts time.Time, ok bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 {
+		return "", "", time.Time{}, false
+	}
+	kind, camera = parts[0], parts[1]
+	ext, found := kindExt[kind]
+	if !found || !strings.HasSuffix(parts[2], "."+ext) {
+		return "", "", time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(strings.TrimSuffix(parts[2], "."+ext), 10, 64)
+	if err != nil {
+		return "", "", time.Time{}, false
+	}
+	return kind, camera, time.Unix(unix, 0), true
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/psanford/rom-cam/metrics"
+)
+
+// WithMetrics wraps b to record upload counts, bytes and latency.
+func WithMetrics(b Backend) Backend {
+	return &instrumented{Backend: b}
+}
+
+type instrumented struct {
+	Backend
+}
+
+func (i *instrumented) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	kind, _, _, ok := ParseKey(key)
+	if !ok {
+		kind = "other"
+	}
+
+	start := time.Now()
+	err := i.Backend.Put(ctx, key, data, contentType)
+	metrics.UploadDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.Uploads.WithLabelValues(kind, metrics.ResultError).Inc()
+		return err
+	}
+	metrics.Uploads.WithLabelValues(kind, metrics.ResultSuccess).Inc()
+	metrics.UploadBytes.WithLabelValues(kind).Add(float64(len(data)))
+	return nil
+}
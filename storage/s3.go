@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/psanford/rom-cam/config"
+)
+
+type S3 struct {
+	client *s3.S3
+	bucket string
+}
+
+func NewS3(bucket string, awsCreds *config.AWSCred) (*S3, error) {
+	var creds *credentials.Credentials
+	if awsCreds != nil {
+		creds = credentials.NewStaticCredentials(awsCreds.AccessKeyID, awsCreds.SecretAccessKey, "")
+	}
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: creds,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3{
+		client: s3.New(sess),
+		bucket: bucket,
+	}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	input := &s3.PutObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+		Body:   bytes.NewReader(data),
+	}
+	if contentType != "" {
+		input.ContentType = &contentType
+	}
+	_, err := s.client.PutObjectWithContext(ctx, input)
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var out []Object
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: &s.bucket,
+		Prefix: &prefix,
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			out = append(out, Object{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return true
+	})
+	return out, err
+}
+
+// maxDeleteBatch is the s3 limit on keys per DeleteObjects call.
+const maxDeleteBatch = 1000
+
+func (s *S3) Delete(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > maxDeleteBatch {
+			batch = batch[:maxDeleteBatch]
+		}
+		keys = keys[len(batch):]
+
+		objs := make([]*s3.ObjectIdentifier, 0, len(batch))
+		for _, key := range batch {
+			objs = append(objs, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		out, err := s.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: &s.bucket,
+			Delete: &s3.Delete{
+				Objects: objs,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("delete %s err: %s", aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+
+	return nil
+}
+
+func (s *S3) SignedURL(key string, expire time.Duration) (string, error) {
+	req, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	return req.Presign(expire)
+}
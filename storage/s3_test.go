@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type deleteRequest struct {
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+// testS3 returns an S3 backed by handler.
+func testS3(t *testing.T, handler http.HandlerFunc) *S3 {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:       aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &S3{client: s3.New(sess), bucket: "footage"}
+}
+
+func TestS3DeleteBatches(t *testing.T) {
+	var batches []int
+	var deleted []string
+	s := testS3(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/footage" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		var req deleteRequest
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		batches = append(batches, len(req.Objects))
+		for _, o := range req.Objects {
+			deleted = append(deleted, o.Key)
+		}
+		fmt.Fprint(w, `<DeleteResult></DeleteResult>`)
+	})
+
+	var keys []string
+	for i := 0; i < 2500; i++ {
+		keys = append(keys, fmt.Sprintf("ts/front/%d.ts", i))
+	}
+	if err := s.Delete(context.Background(), keys); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(batches) != "[1000 1000 500]" {
+		t.Errorf("batches = %v, want [1000 1000 500]", batches)
+	}
+	if len(deleted) != len(keys) {
+		t.Fatalf("deleted %d keys, want %d", len(deleted), len(keys))
+	}
+	for i := range keys {
+		if deleted[i] != keys[i] {
+			t.Fatalf("deleted[%d] = %s, want %s", i, deleted[i], keys[i])
+		}
+	}
+}
+
+func TestS3DeleteErrors(t *testing.T) {
+	requests := 0
+	s := testS3(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `<DeleteResult><Error><Key>ts/front/1.ts</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error></DeleteResult>`)
+	})
+
+	keys := make([]string, 1500)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("ts/front/%d.ts", i)
+	}
+	err := s.Delete(context.Background(), keys)
+	if err == nil {
+		t.Fatal("no error for a failed key")
+	}
+	// later batches aren't attempted after a failure
+	if requests != 1 {
+		t.Errorf("%d requests, want 1", requests)
+	}
+}
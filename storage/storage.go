@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Backend is where footage is persisted after capture.
+type Backend interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Delete(ctx context.Context, keys []string) error
+	SignedURL(key string, expire time.Duration) (string, error)
+}
+
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
@@ -0,0 +1,256 @@
+// Package tsdemux extracts H.264 access units from the capture's mpegts
+// stream for the RTP based outputs.
+package tsdemux
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/Comcast/gots/packet"
+	"github.com/Comcast/gots/pes"
+)
+
+const (
+	naluTypeIDR = 5
+	naluTypeSPS = 7
+	naluTypePPS = 8
+
+	// defaultFrameTicks is one frame at the capture's 10fps.
+	defaultFrameTicks = 9000
+)
+
+// AccessUnit is one H.264 frame in Annex B format.
+type AccessUnit struct {
+	// PTS is the presentation timestamp in 90kHz units, which is also the
+	// RTP clock rate for H.264.
+	PTS  uint64
+	Data []byte
+	// IDR is set if the access unit contains an IDR slice.
+	IDR bool
+}
+
+// Demuxer reassembles video PES packets from mpegts. The video PID is
+// taken from the first PES packet with a video stream id so the PMT does
+// not need to be parsed.
+type Demuxer struct {
+	pid     int
+	partial []byte
+	pes     []byte
+	pts     uint64
+}
+
+func NewDemuxer() *Demuxer {
+	return &Demuxer{
+		pid: -1,
+	}
+}
+
+// Write accepts mpegts data in any chunking and calls emit for each
+// complete access unit. An access unit is complete when the next one
+// starts, so emit lags the input by one frame.
+func (d *Demuxer) Write(data []byte, emit func(AccessUnit)) {
+	if len(d.partial) > 0 {
+		need := packet.PacketSize - len(d.partial)
+		if len(data) < need {
+			d.partial = append(d.partial, data...)
+			return
+		}
+		d.partial = append(d.partial, data[:need]...)
+		data = data[need:]
+		d.writePacket(d.partial, emit)
+		d.partial = d.partial[:0]
+	}
+
+	for len(data) >= packet.PacketSize {
+		d.writePacket(data[:packet.PacketSize], emit)
+		data = data[packet.PacketSize:]
+	}
+	d.partial = append(d.partial, data...)
+}
+
+func (d *Demuxer) writePacket(b []byte, emit func(AccessUnit)) {
+	var pkt packet.Packet
+	copy(pkt[:], b)
+
+	if pkt.CheckErrors() != nil || !pkt.HasPayload() {
+		return
+	}
+	if d.pid >= 0 && pkt.PID() != d.pid {
+		return
+	}
+	payload, err := pkt.Payload()
+	if err != nil {
+		return
+	}
+
+	if !pkt.PayloadUnitStartIndicator() {
+		if d.pes != nil {
+			d.pes = append(d.pes, payload...)
+		}
+		return
+	}
+
+	hdr, err := pes.NewPESHeader(payload)
+	if err != nil || hdr.PacketStartCodePrefix() != 1 {
+		return
+	}
+	if d.pid < 0 {
+		if hdr.StreamId()&0xf0 != 0xe0 {
+			return
+		}
+		d.pid = pkt.PID()
+	}
+
+	d.flush(emit)
+	d.pes = append([]byte(nil), hdr.Data()...)
+	if hdr.HasPTS() {
+		d.pts = hdr.PTS()
+	}
+}
+
+// Flush emits the buffered access unit, for when the input has ended.
+func (d *Demuxer) Flush(emit func(AccessUnit)) {
+	d.flush(emit)
+}
+
+// Reset discards any partial access unit, for when the input skips ahead.
+func (d *Demuxer) Reset() {
+	d.partial = nil
+	d.pes = nil
+}
+
+func (d *Demuxer) flush(emit func(AccessUnit)) {
+	if len(d.pes) == 0 {
+		return
+	}
+	au := AccessUnit{
+		PTS:  d.pts,
+		Data: d.pes,
+	}
+	for _, nalu := range NALUs(au.Data) {
+		if len(nalu) > 0 && nalu[0]&0x1f == naluTypeIDR {
+			au.IDR = true
+			break
+		}
+	}
+	d.pes = nil
+	emit(au)
+}
+
+// NALUs splits Annex B data on its start codes.
+func NALUs(data []byte) [][]byte {
+	var out [][]byte
+	startCode := []byte{0, 0, 1}
+	start := bytes.Index(data, startCode)
+	for start >= 0 {
+		start += len(startCode)
+		next := bytes.Index(data[start:], startCode)
+		if next < 0 {
+			out = append(out, data[start:])
+			break
+		}
+		end := start + next
+		// trailing zero of a 4 byte start code
+		nalEnd := end
+		for nalEnd > start && data[nalEnd-1] == 0 {
+			nalEnd--
+		}
+		out = append(out, data[start:nalEnd])
+		start = end
+	}
+	return out
+}
+
+// ptsWrap is where the 33 bit PTS wraps around.
+const ptsWrap = 1 << 33
+
+// Duration returns the media duration of a segment: the span from the
+// first to the last frame's PTS plus one frame. It returns false if the
+// segment has fewer than two frames.
+func Duration(ts []byte) (time.Duration, bool) {
+	var (
+		first  uint64
+		span   uint64
+		frames int
+	)
+	d := NewDemuxer()
+	add := func(au AccessUnit) {
+		if frames == 0 {
+			first = au.PTS
+		}
+		frames++
+		if off := (au.PTS + ptsWrap - first) % ptsWrap; off > span && off < ptsWrap/2 {
+			span = off
+		}
+	}
+	d.Write(ts, add)
+	d.Flush(add)
+
+	if frames < 2 || span == 0 {
+		return 0, false
+	}
+	ticks := span + span/uint64(frames-1)
+	return time.Duration(ticks) * time.Second / 90000, true
+}
+
+// ParameterSets returns the first SPS and PPS in the mpegts data, or nil
+// if it has none.
+func ParameterSets(ts []byte) (sps, pps []byte) {
+	d := NewDemuxer()
+	find := func(au AccessUnit) {
+		for _, nalu := range NALUs(au.Data) {
+			if len(nalu) == 0 {
+				continue
+			}
+			switch nalu[0] & 0x1f {
+			case naluTypeSPS:
+				if sps == nil {
+					sps = nalu
+				}
+			case naluTypePPS:
+				if pps == nil {
+					pps = nalu
+				}
+			}
+		}
+	}
+	d.Write(ts, find)
+	d.Flush(find)
+	return sps, pps
+}
+
+// RTPClock turns access unit PTSs into a continuous RTP timestamp.
+type RTPClock struct {
+	ts      uint32
+	lastPTS uint64
+	started bool
+}
+
+// CatchUpStart returns the timestamp Next will return for a catch up unit,
+// for announcing before a stream starts.
+func (c *RTPClock) CatchUpStart() uint32 {
+	if c.started {
+		return c.ts + 1
+	}
+	return c.ts
+}
+
+// Next returns the RTP timestamp for pts. Jumps in the capture's clock,
+// such as when ffmpeg restarts, advance the timestamp by one frame.
+// Backlog units sent to catch a new client up to live are given
+// timestamps one tick apart so the client shows them immediately.
+func (c *RTPClock) Next(pts uint64, catchUp bool) uint32 {
+	if c.started {
+		delta := int64(pts) - int64(c.lastPTS)
+		switch {
+		case catchUp:
+			delta = 1
+		case delta <= 0 || delta > 5*90000:
+			delta = defaultFrameTicks
+		}
+		c.ts += uint32(delta)
+	}
+	c.started = true
+	c.lastPTS = pts
+	return c.ts
+}
@@ -0,0 +1,172 @@
+package webrtc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+// offer is what we need from a browser's SDP offer.
+type offer struct {
+	ufrag string
+	pwd   string
+	// fingerprintAlg and fingerprint are the hash of the browser's DTLS
+	// certificate.
+	fingerprintAlg string
+	fingerprint    []byte
+	// videoMid is the mid of the video section we answer. Every other
+	// section is rejected.
+	videoMid   string
+	mids       []string
+	media      []string
+	payloadTyp uint8
+	fmtp       string
+}
+
+var errNoH264 = errors.New("offer has no h264 video with packetization-mode=1")
+
+func parseOffer(raw string) (*offer, error) {
+	var sd sdp.SessionDescription
+	if err := sd.UnmarshalString(raw); err != nil {
+		return nil, fmt.Errorf("parse sdp: %w", err)
+	}
+
+	o := &offer{}
+	videoIdx := -1
+	for i, md := range sd.MediaDescriptions {
+		mid, _ := md.Attribute("mid")
+		o.mids = append(o.mids, mid)
+		o.media = append(o.media, md.MediaName.Media)
+		if md.MediaName.Media != "video" || videoIdx >= 0 {
+			continue
+		}
+		pt, fmtp, ok := chooseH264(&sd, md)
+		if !ok {
+			continue
+		}
+		videoIdx = i
+		o.videoMid = mid
+		o.payloadTyp = pt
+		o.fmtp = fmtp
+	}
+	if videoIdx < 0 {
+		return nil, errNoH264
+	}
+	md := sd.MediaDescriptions[videoIdx]
+
+	attr := func(key string) string {
+		if v, ok := md.Attribute(key); ok {
+			return v
+		}
+		v, _ := sd.Attribute(key)
+		return v
+	}
+
+	o.ufrag = attr("ice-ufrag")
+	o.pwd = attr("ice-pwd")
+	if o.ufrag == "" || o.pwd == "" {
+		return nil, errors.New("offer has no ice credentials")
+	}
+
+	if setup := attr("setup"); setup == "passive" {
+		return nil, errors.New("offer requires us to be the dtls client")
+	}
+
+	alg, fp, ok := strings.Cut(attr("fingerprint"), " ")
+	if !ok {
+		return nil, errors.New("offer has no dtls fingerprint")
+	}
+	o.fingerprintAlg = strings.ToLower(alg)
+	o.fingerprint, _ = hex.DecodeString(strings.ReplaceAll(fp, ":", ""))
+	if len(o.fingerprint) == 0 {
+		return nil, errors.New("offer has an invalid dtls fingerprint")
+	}
+
+	return o, nil
+}
+
+// chooseH264 picks the payload type to send. Browsers offer several H.264
+// profiles; the camera's stream is passed through untouched and decoders
+// accept any of them, so constrained baseline is preferred only because
+// every browser offers it.
+func chooseH264(sd *sdp.SessionDescription, md *sdp.MediaDescription) (uint8, string, bool) {
+	var (
+		best     uint8
+		bestFmtp string
+		found    bool
+	)
+	for _, f := range md.MediaName.Formats {
+		n, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			continue
+		}
+		codec, err := sd.GetCodecForPayloadType(uint8(n))
+		if err != nil || !strings.EqualFold(codec.Name, "h264") {
+			continue
+		}
+		if !strings.Contains(codec.Fmtp, "packetization-mode=1") {
+			continue
+		}
+		if strings.Contains(codec.Fmtp, "profile-level-id=42e01f") {
+			return uint8(n), codec.Fmtp, true
+		}
+		if !found {
+			best, bestFmtp, found = uint8(n), codec.Fmtp, true
+		}
+	}
+	return best, bestFmtp, found
+}
+
+// answer builds our SDP answer. We are an ice-lite agent with host
+// candidates only, and the DTLS server.
+func (o *offer) answer(sess *session, fingerprint string, hosts []net.IP, port int) string {
+	var b bytes.Buffer
+	b.WriteString("v=0\r\n")
+	fmt.Fprintf(&b, "o=- %d 2 IN IP4 127.0.0.1\r\n", sess.ssrc)
+	b.WriteString("s=-\r\n")
+	b.WriteString("t=0 0\r\n")
+	fmt.Fprintf(&b, "a=group:BUNDLE %s\r\n", o.videoMid)
+	b.WriteString("a=ice-lite\r\n")
+
+	for i, media := range o.media {
+		if o.mids[i] != o.videoMid {
+			// reject everything but the video we send
+			fmt.Fprintf(&b, "m=%s 0 UDP/TLS/RTP/SAVPF 0\r\n", media)
+			b.WriteString("c=IN IP4 0.0.0.0\r\n")
+			if o.mids[i] != "" {
+				fmt.Fprintf(&b, "a=mid:%s\r\n", o.mids[i])
+			}
+			b.WriteString("a=inactive\r\n")
+			continue
+		}
+
+		fmt.Fprintf(&b, "m=video 9 UDP/TLS/RTP/SAVPF %d\r\n", o.payloadTyp)
+		b.WriteString("c=IN IP4 0.0.0.0\r\n")
+		fmt.Fprintf(&b, "a=mid:%s\r\n", o.videoMid)
+		fmt.Fprintf(&b, "a=ice-ufrag:%s\r\n", sess.ufrag)
+		fmt.Fprintf(&b, "a=ice-pwd:%s\r\n", sess.pwd)
+		fmt.Fprintf(&b, "a=fingerprint:sha-256 %s\r\n", fingerprint)
+		b.WriteString("a=setup:passive\r\n")
+		b.WriteString("a=sendonly\r\n")
+		b.WriteString("a=rtcp-mux\r\n")
+		fmt.Fprintf(&b, "a=rtpmap:%d H264/90000\r\n", o.payloadTyp)
+		if o.fmtp != "" {
+			fmt.Fprintf(&b, "a=fmtp:%d %s\r\n", o.payloadTyp, o.fmtp)
+		}
+		b.WriteString("a=msid:rom-cam video\r\n")
+		fmt.Fprintf(&b, "a=ssrc:%d cname:rom-cam\r\n", sess.ssrc)
+		fmt.Fprintf(&b, "a=ssrc:%d msid:rom-cam video\r\n", sess.ssrc)
+		for j, ip := range hosts {
+			fmt.Fprintf(&b, "a=candidate:%d 1 udp %d %s %d typ host\r\n", j+1, 2130706431-j, ip, port)
+		}
+		b.WriteString("a=end-of-candidates\r\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,349 @@
+package webrtc
+
+import (
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pion/sdp/v3"
+)
+
+// chromeOffer is a WHEP offer in the form Chrome 126 sends for a recvonly
+// video transceiver, as app.js creates. Credentials are made up.
+const chromeOffer = `v=0
+o=- 4215775240449105457 2 IN IP4 127.0.0.1
+s=-
+t=0 0
+a=group:BUNDLE 0
+a=extmap-allow-mixed
+a=msid-semantic: WMS
+m=video 9 UDP/TLS/RTP/SAVPF 96 97 102 103 104 105 106 107 108 109 127 125 39 40 45 46 98 99 100 101 112 113 116 117 118
+c=IN IP4 0.0.0.0
+a=rtcp:9 IN IP4 0.0.0.0
+a=ice-ufrag:EsAw
+a=ice-pwd:bP+XJMM09aR8AiX1jdukzR6Y
+a=ice-options:trickle
+a=fingerprint:sha-256 DA:39:A3:EE:5E:6B:4B:0D:32:55:BF:EF:95:60:18:90:AF:D8:07:09:1C:3A:2D:26:6B:8C:14:B5:7F:63:8A:2E
+a=setup:actpass
+a=mid:0
+a=extmap:1 urn:ietf:params:rtp-hdrext:toffset
+a=extmap:2 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time
+a=extmap:3 urn:3gpp:video-orientation
+a=extmap:4 http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01
+a=recvonly
+a=rtcp-mux
+a=rtcp-rsize
+a=rtpmap:96 VP8/90000
+a=rtcp-fb:96 goog-remb
+a=rtcp-fb:96 transport-cc
+a=rtcp-fb:96 ccm fir
+a=rtcp-fb:96 nack
+a=rtcp-fb:96 nack pli
+a=rtpmap:97 rtx/90000
+a=fmtp:97 apt=96
+a=rtpmap:102 H264/90000
+a=rtcp-fb:102 goog-remb
+a=rtcp-fb:102 transport-cc
+a=rtcp-fb:102 ccm fir
+a=rtcp-fb:102 nack
+a=rtcp-fb:102 nack pli
+a=fmtp:102 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f
+a=rtpmap:103 rtx/90000
+a=fmtp:103 apt=102
+a=rtpmap:104 H264/90000
+a=rtcp-fb:104 nack pli
+a=fmtp:104 level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42001f
+a=rtpmap:105 rtx/90000
+a=fmtp:105 apt=104
+a=rtpmap:106 H264/90000
+a=rtcp-fb:106 goog-remb
+a=rtcp-fb:106 transport-cc
+a=rtcp-fb:106 ccm fir
+a=rtcp-fb:106 nack
+a=rtcp-fb:106 nack pli
+a=fmtp:106 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f
+a=rtpmap:107 rtx/90000
+a=fmtp:107 apt=106
+a=rtpmap:108 H264/90000
+a=rtcp-fb:108 nack pli
+a=fmtp:108 level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f
+a=rtpmap:109 rtx/90000
+a=fmtp:109 apt=108
+a=rtpmap:127 H264/90000
+a=rtcp-fb:127 nack pli
+a=fmtp:127 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f
+a=rtpmap:125 rtx/90000
+a=fmtp:125 apt=127
+a=rtpmap:39 H264/90000
+a=rtcp-fb:39 nack pli
+a=fmtp:39 level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=4d001f
+a=rtpmap:40 rtx/90000
+a=fmtp:40 apt=39
+a=rtpmap:45 AV1/90000
+a=rtcp-fb:45 nack pli
+a=fmtp:45 level-idx=5;profile=0;tier=0
+a=rtpmap:46 rtx/90000
+a=fmtp:46 apt=45
+a=rtpmap:98 VP9/90000
+a=rtcp-fb:98 nack pli
+a=fmtp:98 profile-id=0
+a=rtpmap:99 rtx/90000
+a=fmtp:99 apt=98
+a=rtpmap:100 VP9/90000
+a=rtcp-fb:100 nack pli
+a=fmtp:100 profile-id=2
+a=rtpmap:101 rtx/90000
+a=fmtp:101 apt=100
+a=rtpmap:112 H264/90000
+a=rtcp-fb:112 nack pli
+a=fmtp:112 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=64001f
+a=rtpmap:113 rtx/90000
+a=fmtp:113 apt=112
+a=rtpmap:116 red/90000
+a=rtpmap:117 rtx/90000
+a=fmtp:117 apt=116
+a=rtpmap:118 ulpfec/90000
+`
+
+// firefoxOffer is the same in the form Firefox 127 sends. Firefox puts
+// the fingerprint at the session level.
+const firefoxOffer = `v=0
+o=mozilla...THIS_IS_SDPARTA-99.0 5619370960063424556 0 IN IP4 0.0.0.0
+s=-
+t=0 0
+a=fingerprint:sha-256 4F:5B:6C:8E:B9:27:34:16:C7:0B:6B:2A:4F:82:0E:1A:AF:D1:21:93:9A:0E:6E:2C:7B:83:D5:E8:6C:3A:50:11
+a=group:BUNDLE 0
+a=ice-options:trickle
+a=msid-semantic:WMS *
+m=video 9 UDP/TLS/RTP/SAVPF 120 124 121 125 126 127 97 98 123 122 119
+c=IN IP4 0.0.0.0
+a=recvonly
+a=extmap:3 urn:ietf:params:rtp-hdrext:sdes:mid
+a=extmap:4 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time
+a=extmap:5 urn:ietf:params:rtp-hdrext:toffset
+a=extmap:6/recvonly http://www.webrtc.org/experiments/rtp-hdrext/playout-delay
+a=extmap:7 http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01
+a=fmtp:126 profile-level-id=42e01f;level-asymmetry-allowed=1;packetization-mode=1
+a=fmtp:97 profile-level-id=42e01f;level-asymmetry-allowed=1
+a=fmtp:120 max-fs=12288;max-fr=60
+a=fmtp:124 apt=120
+a=fmtp:121 max-fs=12288;max-fr=60
+a=fmtp:125 apt=121
+a=fmtp:127 apt=126
+a=fmtp:98 apt=97
+a=fmtp:119 apt=122
+a=ice-pwd:8d3a3d2c0a5f0e0b3b9a7f0f2a1c4e6d
+a=ice-ufrag:5c7a0f1e
+a=mid:0
+a=rtcp-fb:120 nack
+a=rtcp-fb:120 nack pli
+a=rtcp-fb:120 ccm fir
+a=rtcp-fb:120 goog-remb
+a=rtcp-fb:120 transport-cc
+a=rtcp-fb:121 nack
+a=rtcp-fb:121 nack pli
+a=rtcp-fb:121 ccm fir
+a=rtcp-fb:121 goog-remb
+a=rtcp-fb:121 transport-cc
+a=rtcp-fb:126 nack
+a=rtcp-fb:126 nack pli
+a=rtcp-fb:126 ccm fir
+a=rtcp-fb:126 goog-remb
+a=rtcp-fb:126 transport-cc
+a=rtcp-fb:97 nack
+a=rtcp-fb:97 nack pli
+a=rtcp-fb:97 ccm fir
+a=rtcp-fb:97 goog-remb
+a=rtcp-fb:97 transport-cc
+a=rtcp-fb:123 nack
+a=rtcp-fb:123 nack pli
+a=rtcp-fb:123 ccm fir
+a=rtcp-fb:123 goog-remb
+a=rtcp-fb:123 transport-cc
+a=rtcp-mux
+a=rtcp-rsize
+a=rtpmap:120 VP8/90000
+a=rtpmap:124 rtx/90000
+a=rtpmap:121 VP9/90000
+a=rtpmap:125 rtx/90000
+a=rtpmap:126 H264/90000
+a=rtpmap:127 rtx/90000
+a=rtpmap:97 H264/90000
+a=rtpmap:98 rtx/90000
+a=rtpmap:123 AV1/90000
+a=rtpmap:122 red/90000
+a=rtpmap:119 rtx/90000
+a=setup:actpass
+a=ssrc:1828587405 cname:{5d1c6a0e-3c1a-4b8e-9a3c-2f0b6b7d1e44}
+`
+
+// crlf converts the offers above to the line endings browsers send.
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+func TestParseOffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		ufrag    string
+		pwd      string
+		fpPrefix string
+		pt       uint8
+		fmtp     string
+	}{
+		{
+			name:     "chrome",
+			raw:      chromeOffer,
+			ufrag:    "EsAw",
+			pwd:      "bP+XJMM09aR8AiX1jdukzR6Y",
+			fpPrefix: "da39a3ee",
+			pt:       106,
+			fmtp:     "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+		},
+		{
+			name:     "firefox",
+			raw:      firefoxOffer,
+			ufrag:    "5c7a0f1e",
+			pwd:      "8d3a3d2c0a5f0e0b3b9a7f0f2a1c4e6d",
+			fpPrefix: "4f5b6c8e",
+			pt:       126,
+			fmtp:     "profile-level-id=42e01f;level-asymmetry-allowed=1;packetization-mode=1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := parseOffer(crlf(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.ufrag != tt.ufrag || o.pwd != tt.pwd {
+				t.Errorf("ice credentials = %q %q, want %q %q", o.ufrag, o.pwd, tt.ufrag, tt.pwd)
+			}
+			if o.fingerprintAlg != "sha-256" || len(o.fingerprint) != 32 {
+				t.Errorf("fingerprint = %s %x", o.fingerprintAlg, o.fingerprint)
+			}
+			if got := hex.EncodeToString(o.fingerprint[:4]); got != tt.fpPrefix {
+				t.Errorf("fingerprint starts %s, want %s", got, tt.fpPrefix)
+			}
+			if o.videoMid != "0" {
+				t.Errorf("videoMid = %q, want %q", o.videoMid, "0")
+			}
+			if o.payloadTyp != tt.pt {
+				t.Errorf("payload type = %d, want %d", o.payloadTyp, tt.pt)
+			}
+			if o.fmtp != tt.fmtp {
+				t.Errorf("fmtp = %q, want %q", o.fmtp, tt.fmtp)
+			}
+		})
+	}
+}
+
+func TestParseOfferErrors(t *testing.T) {
+	// a browser with H.264 disabled
+	noH264 := strings.NewReplacer(
+		"a=rtpmap:126 H264/90000", "a=rtpmap:126 VP8/90000",
+		"a=rtpmap:97 H264/90000", "a=rtpmap:97 VP8/90000",
+	).Replace(firefoxOffer)
+	if _, err := parseOffer(crlf(noH264)); err != errNoH264 {
+		t.Errorf("no h264: err = %v, want %v", err, errNoH264)
+	}
+
+	passive := strings.Replace(chromeOffer, "a=setup:actpass", "a=setup:passive", 1)
+	if _, err := parseOffer(crlf(passive)); err == nil {
+		t.Error("setup:passive offer accepted")
+	}
+
+	noFingerprint := strings.Replace(chromeOffer, "a=fingerprint:", "a=x-fingerprint:", 1)
+	if _, err := parseOffer(crlf(noFingerprint)); err == nil {
+		t.Error("offer without a fingerprint accepted")
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	// an audio section too, as if the page also asked for audio
+	offerWithAudio := strings.Replace(firefoxOffer, "a=group:BUNDLE 0", "a=group:BUNDLE 0 1", 1) +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 109\n" +
+		"c=IN IP4 0.0.0.0\n" +
+		"a=recvonly\n" +
+		"a=mid:1\n" +
+		"a=rtpmap:109 opus/48000/2\n"
+
+	for name, raw := range map[string]string{"chrome": chromeOffer, "firefox": offerWithAudio} {
+		t.Run(name, func(t *testing.T) {
+			o, err := parseOffer(crlf(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess := &session{ufrag: "abcd1234", pwd: "0123456789abcdefghijklmn", ssrc: 1234}
+			hosts := []net.IP{net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10")}
+			ans := o.answer(sess, "AA:BB", hosts, 8189)
+
+			var sd sdp.SessionDescription
+			if err := sd.UnmarshalString(ans); err != nil {
+				t.Fatalf("answer doesn't parse: %s\n%s", err, ans)
+			}
+			if _, ok := sd.Attribute("ice-lite"); !ok {
+				t.Error("answer is not ice-lite")
+			}
+			if len(sd.MediaDescriptions) != len(o.media) {
+				t.Fatalf("answer has %d media sections, offer has %d", len(sd.MediaDescriptions), len(o.media))
+			}
+
+			video := sd.MediaDescriptions[0]
+			if video.MediaName.Port.Value != 9 {
+				t.Errorf("video port = %d, want 9", video.MediaName.Port.Value)
+			}
+			pt := strconv.Itoa(int(o.payloadTyp))
+			if got := strings.Join(video.MediaName.Formats, " "); got != pt {
+				t.Errorf("video formats = %q, want %q", got, pt)
+			}
+			wantAttrs := map[string]string{
+				"mid":         "0",
+				"ice-ufrag":   sess.ufrag,
+				"ice-pwd":     sess.pwd,
+				"fingerprint": "sha-256 AA:BB",
+				"setup":       "passive",
+				"rtpmap":      pt + " H264/90000",
+				"fmtp":        pt + " " + o.fmtp,
+			}
+			for k, want := range wantAttrs {
+				if got, _ := video.Attribute(k); got != want {
+					t.Errorf("a=%s = %q, want %q", k, got, want)
+				}
+			}
+			for _, k := range []string{"sendonly", "rtcp-mux", "end-of-candidates"} {
+				if _, ok := video.Attribute(k); !ok {
+					t.Errorf("video has no a=%s", k)
+				}
+			}
+
+			var candidates []string
+			for _, a := range video.Attributes {
+				if a.Key == "candidate" {
+					candidates = append(candidates, a.Value)
+				}
+			}
+			if len(candidates) != len(hosts) {
+				t.Fatalf("candidates = %q, want one per host", candidates)
+			}
+			for i, c := range candidates {
+				if !strings.Contains(c, " "+hosts[i].String()+" 8189 typ host") {
+					t.Errorf("candidate %q doesn't match host %s", c, hosts[i])
+				}
+			}
+
+			for _, md := range sd.MediaDescriptions[1:] {
+				if md.MediaName.Port.Value != 0 {
+					t.Errorf("%s section not rejected", md.MediaName.Media)
+				}
+				if _, ok := md.Attribute("inactive"); !ok {
+					t.Errorf("%s section not inactive", md.MediaName.Media)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+package webrtc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	_ "crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/dtls/v3"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/srtp/v3"
+	"github.com/psanford/rom-cam/tsdemux"
+)
+
+const (
+	// rtpMTU leaves room for the SRTP auth tag and IPv6 headers.
+	rtpMTU = 1200
+	// minKeyframeInterval limits how often a browser's picture loss
+	// reports restart the stream. Browsers repeat them until they get an
+	// IDR frame.
+	minKeyframeInterval = 2 * time.Second
+)
+
+// session is one browser viewer.
+type session struct {
+	srv     *Server
+	id      string
+	ufrag   string
+	pwd     string
+	ssrc    uint32
+	offer   *offer
+	created time.Time
+
+	dtlsIn chan []byte
+	// keyframe is signaled when the browser asks for an IDR frame.
+	keyframe chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mu       sync.Mutex
+	addr     *net.UDPAddr
+	lastSeen time.Time
+	// srtcp decrypts rtcp from the browser. It is only used by the
+	// server's read loop once set.
+	srtcp *srtp.Context
+
+	// only used by the stream goroutine
+	srtp      *srtp.Context
+	payloader codecs.H264Payloader
+	seq       uint16
+	clock     tsdemux.RTPClock
+}
+
+// checked records a successful connectivity check from addr. It returns
+// true if addr is now the session's remote address. The first checked
+// address is used until the browser nominates one with USE-CANDIDATE.
+func (sess *session) checked(addr *net.UDPAddr, nominated bool) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.lastSeen = time.Now()
+	if sess.addr != nil && !nominated {
+		return sess.addr.String() == addr.String()
+	}
+	first := sess.addr == nil
+	sess.addr = addr
+	if first {
+		go sess.run()
+	}
+	return true
+}
+
+func (sess *session) remoteAddr() *net.UDPAddr {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.addr
+}
+
+func (sess *session) stale() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.addr == nil {
+		return time.Since(sess.created) > connectTimeout
+	}
+	return time.Since(sess.lastSeen) > consentTimeout
+}
+
+func (sess *session) receiveDTLS(pkt []byte) {
+	select {
+	case sess.dtlsIn <- pkt:
+	default:
+	}
+}
+
+// receiveRTCP handles feedback from the browser. Picture loss and full
+// intra requests ask the stream to send an IDR frame.
+func (sess *session) receiveRTCP(pkt []byte) {
+	sess.mu.Lock()
+	srtcp := sess.srtcp
+	sess.mu.Unlock()
+	if srtcp == nil {
+		return
+	}
+
+	raw, err := srtcp.DecryptRTCP(nil, pkt, nil)
+	if err != nil {
+		return
+	}
+	pkts, err := rtcp.Unmarshal(raw)
+	if err != nil {
+		return
+	}
+	for _, p := range pkts {
+		switch p.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			select {
+			case sess.keyframe <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// run does the DTLS handshake then streams until the session ends.
+func (sess *session) run() {
+	lgr := sess.srv.lgr.New("id", sess.id)
+	defer sess.srv.remove(sess)
+
+	err := sess.handshake()
+	if err != nil {
+		if sess.ctx.Err() == nil {
+			lgr.Error("webrtc_dtls_err", "err", err)
+		}
+		return
+	}
+	lgr.Info("webrtc_session_connected", "addr", sess.remoteAddr())
+
+	err = sess.stream()
+	if err != nil && sess.ctx.Err() == nil {
+		lgr.Error("webrtc_stream_err", "err", err)
+	}
+}
+
+func (sess *session) handshake() error {
+	conf := &dtls.Config{
+		Certificates:         []tls.Certificate{sess.srv.cert},
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		SRTPProtectionProfiles: []dtls.SRTPProtectionProfile{
+			dtls.SRTP_AEAD_AES_128_GCM,
+			dtls.SRTP_AES128_CM_HMAC_SHA1_80,
+		},
+		ClientAuth:            dtls.RequireAnyClientCert,
+		VerifyPeerCertificate: sess.verifyFingerprint,
+	}
+
+	transport := newPacketConn(sess)
+	conn, err := dtls.Server(transport, sess.remoteAddr(), conf)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-sess.ctx.Done()
+		conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(sess.ctx, connectTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+
+	dtlsProfile, ok := conn.SelectedSRTPProtectionProfile()
+	if !ok {
+		return errors.New("no srtp protection profile negotiated")
+	}
+	var profile srtp.ProtectionProfile
+	switch dtlsProfile {
+	case dtls.SRTP_AEAD_AES_128_GCM:
+		profile = srtp.ProtectionProfileAeadAes128Gcm
+	case dtls.SRTP_AES128_CM_HMAC_SHA1_80:
+		profile = srtp.ProtectionProfileAes128CmHmacSha1_80
+	default:
+		return fmt.Errorf("unsupported srtp protection profile %d", dtlsProfile)
+	}
+
+	state, ok := conn.ConnectionState()
+	if !ok {
+		return errors.New("dtls connection state unavailable")
+	}
+	srtpConf := srtp.Config{Profile: profile}
+	if err := srtpConf.ExtractSessionKeysFromDTLS(&state, false); err != nil {
+		return err
+	}
+	sess.srtp, err = srtp.CreateContext(srtpConf.Keys.LocalMasterKey, srtpConf.Keys.LocalMasterSalt, profile)
+	if err != nil {
+		return err
+	}
+	srtcp, err := srtp.CreateContext(srtpConf.Keys.RemoteMasterKey, srtpConf.Keys.RemoteMasterSalt, profile)
+	if err != nil {
+		return err
+	}
+	sess.mu.Lock()
+	sess.srtcp = srtcp
+	sess.mu.Unlock()
+
+	// reading processes alerts; the browser closing the connection ends
+	// the session
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				sess.cancel()
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// verifyFingerprint checks the browser's certificate against the
+// fingerprint from its offer, which is what authenticates the DTLS
+// connection.
+func (sess *session) verifyFingerprint(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no client certificate")
+	}
+	hashes := map[string]crypto.Hash{
+		"sha-1":   crypto.SHA1,
+		"sha-256": crypto.SHA256,
+		"sha-384": crypto.SHA384,
+		"sha-512": crypto.SHA512,
+	}
+	h, ok := hashes[sess.offer.fingerprintAlg]
+	if !ok || !h.Available() {
+		return fmt.Errorf("unsupported fingerprint algorithm %q", sess.offer.fingerprintAlg)
+	}
+	hasher := h.New()
+	hasher.Write(rawCerts[0])
+	if !bytes.Equal(hasher.Sum(nil), sess.offer.fingerprint) {
+		return errors.New("client certificate does not match offer fingerprint")
+	}
+	return nil
+}
+
+// stream sends the live stream until the session ends. The first read
+// from the subscription is the current segment so far. It starts with an
+// IDR frame, so it is sent immediately with compressed timestamps; the
+// browser decodes it at once and then plays live packets as they arrive.
+// When the browser reports picture loss the stream restarts the same way
+// from the current segment.
+func (sess *session) stream() error {
+	packets, unsubscribe := sess.srv.live.Subscribe(4096)
+	defer func() {
+		unsubscribe()
+	}()
+	restarted := time.Now()
+
+	demux := tsdemux.NewDemuxer()
+	var (
+		err      error
+		catchUp  = true
+		haveIDR  bool
+		sendUnit = func(au tsdemux.AccessUnit) {
+			if err != nil {
+				return
+			}
+			if !haveIDR && !au.IDR {
+				return
+			}
+			haveIDR = true
+			err = sess.writeAccessUnit(au, catchUp)
+			if catchUp {
+				// don't overrun socket buffers with the backlog
+				time.Sleep(time.Millisecond)
+			}
+		}
+	)
+
+	for {
+		select {
+		case <-sess.ctx.Done():
+			return nil
+		case <-sess.keyframe:
+			if time.Since(restarted) < minKeyframeInterval {
+				continue
+			}
+			restarted = time.Now()
+			unsubscribe()
+			packets, unsubscribe = sess.srv.live.Subscribe(4096)
+			demux.Reset()
+			haveIDR = false
+			catchUp = true
+		case pkt, ok := <-packets:
+			if !ok {
+				return nil
+			}
+			if len(pkt) == 0 {
+				// we fell behind and skipped to the next segment
+				demux.Reset()
+				haveIDR = false
+				continue
+			}
+			demux.Write(pkt, sendUnit)
+			catchUp = false
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (sess *session) writeAccessUnit(au tsdemux.AccessUnit, catchUp bool) error {
+	ts := sess.clock.Next(au.PTS, catchUp)
+	addr := sess.remoteAddr()
+	payloads := sess.payloader.Payload(rtpMTU, au.Data)
+	for i, payload := range payloads {
+		pkt := rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i == len(payloads)-1,
+				PayloadType:    sess.offer.payloadTyp,
+				SequenceNumber: sess.seq,
+				Timestamp:      ts,
+				SSRC:           sess.ssrc,
+			},
+			Payload: payload,
+		}
+		sess.seq++
+
+		raw, err := pkt.Marshal()
+		if err != nil {
+			return err
+		}
+		enc, err := sess.srtp.EncryptRTP(nil, raw, &pkt.Header)
+		if err != nil {
+			return err
+		}
+		if _, err := sess.srv.conn.WriteToUDP(enc, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packetConn gives the DTLS library a net.PacketConn for one session on
+// the shared socket. Reads come from the server's demux, writes go to the
+// session's current remote address.
+type packetConn struct {
+	sess *session
+
+	mu       sync.Mutex
+	deadline time.Time
+	// deadlineChanged is closed and replaced when the deadline changes so
+	// blocked reads notice.
+	deadlineChanged chan struct{}
+}
+
+func newPacketConn(sess *session) *packetConn {
+	return &packetConn{
+		sess:            sess,
+		deadlineChanged: make(chan struct{}),
+	}
+}
+
+func (c *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		deadline := c.deadline
+		changed := c.deadlineChanged
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		var (
+			n   int
+			err error
+			got bool
+		)
+		select {
+		case <-c.sess.ctx.Done():
+			err, got = net.ErrClosed, true
+		case pkt := <-c.sess.dtlsIn:
+			n, got = copy(p, pkt), true
+		case <-timeout:
+			err, got = os.ErrDeadlineExceeded, true
+		case <-changed:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if got {
+			if err != nil {
+				return 0, nil, err
+			}
+			return n, c.sess.remoteAddr(), nil
+		}
+	}
+}
+
+func (c *packetConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	if c.sess.ctx.Err() != nil {
+		return 0, net.ErrClosed
+	}
+	return c.sess.srv.conn.WriteToUDP(p, c.sess.remoteAddr())
+}
+
+func (c *packetConn) Close() error {
+	return nil
+}
+
+func (c *packetConn) LocalAddr() net.Addr {
+	return c.sess.srv.conn.LocalAddr()
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
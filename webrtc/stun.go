@@ -0,0 +1,157 @@
+package webrtc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net"
+)
+
+// Just enough STUN (RFC 5389) to answer ICE connectivity checks as an
+// ice-lite agent.
+
+const (
+	stunHeaderLen   = 20
+	stunMagicCookie = 0x2112a442
+
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+
+	stunAttrUsername         = 0x0006
+	stunAttrMessageIntegrity = 0x0008
+	stunAttrXORMappedAddress = 0x0020
+	stunAttrUseCandidate     = 0x0025
+	stunAttrFingerprint      = 0x8028
+
+	stunFingerprintXOR = 0x5354554e
+)
+
+var errNotBindingRequest = errors.New("not a stun binding request")
+
+type stunRequest struct {
+	txID         []byte
+	username     string
+	useCandidate bool
+	// integrityOffset is the offset of the MESSAGE-INTEGRITY attribute.
+	integrityOffset int
+	integrity       []byte
+	raw             []byte
+}
+
+func isSTUN(b []byte) bool {
+	return len(b) >= stunHeaderLen && b[0] < 4 && binary.BigEndian.Uint32(b[4:8]) == stunMagicCookie
+}
+
+func parseSTUNRequest(b []byte) (*stunRequest, error) {
+	if !isSTUN(b) || binary.BigEndian.Uint16(b[0:2]) != stunBindingRequest {
+		return nil, errNotBindingRequest
+	}
+	msgLen := int(binary.BigEndian.Uint16(b[2:4]))
+	if stunHeaderLen+msgLen > len(b) {
+		return nil, errors.New("short stun message")
+	}
+
+	req := &stunRequest{
+		txID: b[8:20],
+		raw:  b[:stunHeaderLen+msgLen],
+	}
+
+	attrs := req.raw[stunHeaderLen:]
+	offset := stunHeaderLen
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:2])
+		n := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+n > len(attrs) {
+			return nil, errors.New("short stun attribute")
+		}
+		val := attrs[4 : 4+n]
+
+		switch typ {
+		case stunAttrUsername:
+			req.username = string(val)
+		case stunAttrUseCandidate:
+			req.useCandidate = true
+		case stunAttrMessageIntegrity:
+			req.integrityOffset = offset
+			req.integrity = val
+		}
+
+		padded := (n + 3) &^ 3
+		if 4+padded > len(attrs) {
+			break
+		}
+		attrs = attrs[4+padded:]
+		offset += 4 + padded
+	}
+
+	return req, nil
+}
+
+// verify checks the request's MESSAGE-INTEGRITY with the short term
+// credential pwd.
+func (r *stunRequest) verify(pwd string) bool {
+	if r.integrity == nil {
+		return false
+	}
+	msg := append([]byte(nil), r.raw[:r.integrityOffset]...)
+	// the length covers everything up to and including MESSAGE-INTEGRITY
+	binary.BigEndian.PutUint16(msg[2:4], uint16(r.integrityOffset-stunHeaderLen+24))
+
+	mac := hmac.New(sha1.New, []byte(pwd))
+	mac.Write(msg)
+	return hmac.Equal(mac.Sum(nil), r.integrity)
+}
+
+// bindingResponse builds a success response to req that reports addr as
+// the mapped address.
+func bindingResponse(req *stunRequest, addr *net.UDPAddr, pwd string) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{byte(stunBindingResponse >> 8), byte(stunBindingResponse & 0xff), 0, 0})
+	binary.Write(&b, binary.BigEndian, uint32(stunMagicCookie))
+	b.Write(req.txID)
+
+	var cookie [16]byte
+	binary.BigEndian.PutUint32(cookie[0:4], stunMagicCookie)
+	copy(cookie[4:], req.txID)
+
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+	xorAddr := make([]byte, 4+len(ip))
+	xorAddr[1] = family
+	binary.BigEndian.PutUint16(xorAddr[2:4], uint16(addr.Port)^uint16(stunMagicCookie>>16))
+	for i := range ip {
+		xorAddr[4+i] = ip[i] ^ cookie[i]
+	}
+	writeSTUNAttr(&b, stunAttrXORMappedAddress, xorAddr)
+
+	// MESSAGE-INTEGRITY covers the header with the length including itself
+	msg := b.Bytes()
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)-stunHeaderLen+24))
+	mac := hmac.New(sha1.New, []byte(pwd))
+	mac.Write(msg)
+	writeSTUNAttr(&b, stunAttrMessageIntegrity, mac.Sum(nil))
+
+	msg = b.Bytes()
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)-stunHeaderLen+8))
+	var fp [4]byte
+	binary.BigEndian.PutUint32(fp[:], crc32.ChecksumIEEE(msg)^stunFingerprintXOR)
+	writeSTUNAttr(&b, stunAttrFingerprint, fp[:])
+
+	return b.Bytes()
+}
+
+func writeSTUNAttr(b *bytes.Buffer, typ uint16, val []byte) {
+	binary.Write(b, binary.BigEndian, typ)
+	binary.Write(b, binary.BigEndian, uint16(len(val)))
+	b.Write(val)
+	for i := len(val); i%4 != 0; i++ {
+		b.WriteByte(0)
+	}
+}
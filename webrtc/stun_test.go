@@ -0,0 +1,238 @@
+package webrtc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/config"
+)
+
+// Test vectors from RFC 5769.
+const (
+	rfc5769Password = "VOkJxbRl1RmTxUk/WvJxBt"
+
+	// section 2.1, sample request
+	rfc5769Request = `
+		00 01 00 58 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+		80 22 00 10 53 54 55 4e 20 74 65 73 74 20 63 6c 69 65 6e 74
+		00 24 00 04 6e 00 01 ff
+		80 29 00 08 93 2f f9 b1 51 26 3b 36
+		00 06 00 09 65 76 74 6a 3a 68 36 76 59 20 20 20
+		00 08 00 14 9a ea a7 0c bf d8 cb 56 78 1e f2 b5 b2 d3 f2 49 c1 b5 71 a2
+		80 28 00 04 e5 7a 3b cf`
+
+	// sections 2.2 and 2.3, the XOR-MAPPED-ADDRESS values of the sample
+	// responses
+	rfc5769XORMappedIPv4 = "00 01 a1 47 e1 12 a6 43"
+	rfc5769XORMappedIPv6 = `
+		00 02 a1 47 01 13 a9 fa a5 d3 f1 79 bc 25 f4 b5 be d2 b9 d9`
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseSTUNRequest(t *testing.T) {
+	pkt := unhex(t, rfc5769Request)
+	if !isSTUN(pkt) {
+		t.Fatal("isSTUN = false for the sample request")
+	}
+
+	req, err := parseSTUNRequest(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.username != "evtj:h6vY" {
+		t.Errorf("username = %q, want %q", req.username, "evtj:h6vY")
+	}
+	if req.useCandidate {
+		t.Error("useCandidate = true, want false")
+	}
+	if !bytes.Equal(req.txID, pkt[8:20]) {
+		t.Errorf("txID = %x, want %x", req.txID, pkt[8:20])
+	}
+
+	if !req.verify(rfc5769Password) {
+		t.Error("verify failed with the right password")
+	}
+	if req.verify("wrong password") {
+		t.Error("verify passed with the wrong password")
+	}
+}
+
+func TestParseSTUNRequestErrors(t *testing.T) {
+	pkt := unhex(t, rfc5769Request)
+
+	resp := append([]byte(nil), pkt...)
+	binary.BigEndian.PutUint16(resp[0:2], stunBindingResponse)
+	if _, err := parseSTUNRequest(resp); err != errNotBindingRequest {
+		t.Errorf("response: err = %v, want %v", err, errNotBindingRequest)
+	}
+
+	if _, err := parseSTUNRequest(pkt[:len(pkt)-8]); err == nil {
+		t.Error("truncated message parsed")
+	}
+
+	// MESSAGE-INTEGRITY is covered by the hmac so any change fails
+	tampered := append([]byte(nil), pkt...)
+	tampered[len(tampered)-40] ^= 1
+	req, err := parseSTUNRequest(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.verify(rfc5769Password) {
+		t.Error("verify passed for a tampered message")
+	}
+}
+
+func TestBindingResponse(t *testing.T) {
+	req, err := parseSTUNRequest(unhex(t, rfc5769Request))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		addr *net.UDPAddr
+		want string
+	}{
+		{"ipv4", &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 32853}, rfc5769XORMappedIPv4},
+		{"ipv6", &net.UDPAddr{IP: net.ParseIP("2001:db8:1234:5678:11:2233:4455:6677"), Port: 32853}, rfc5769XORMappedIPv6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := bindingResponse(req, tt.addr, rfc5769Password)
+			attrs := checkResponse(t, resp, req.txID)
+
+			got := attrs[stunAttrXORMappedAddress]
+			if want := unhex(t, tt.want); !bytes.Equal(got, want) {
+				t.Errorf("XOR-MAPPED-ADDRESS = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+// checkResponse validates a binding success response's header,
+// MESSAGE-INTEGRITY and FINGERPRINT and returns its attributes.
+func checkResponse(t *testing.T, resp, txID []byte) map[uint16][]byte {
+	t.Helper()
+
+	if len(resp) < stunHeaderLen {
+		t.Fatalf("short response %x", resp)
+	}
+	if typ := binary.BigEndian.Uint16(resp[0:2]); typ != stunBindingResponse {
+		t.Fatalf("type = %#04x, want %#04x", typ, stunBindingResponse)
+	}
+	if n := int(binary.BigEndian.Uint16(resp[2:4])); n != len(resp)-stunHeaderLen {
+		t.Fatalf("length = %d, want %d", n, len(resp)-stunHeaderLen)
+	}
+	if cookie := binary.BigEndian.Uint32(resp[4:8]); cookie != stunMagicCookie {
+		t.Fatalf("cookie = %#x", cookie)
+	}
+	if !bytes.Equal(resp[8:20], txID) {
+		t.Fatalf("txID = %x, want %x", resp[8:20], txID)
+	}
+
+	attrs := make(map[uint16][]byte)
+	var order []uint16
+	offset := stunHeaderLen
+	for offset+4 <= len(resp) {
+		typ := binary.BigEndian.Uint16(resp[offset : offset+2])
+		n := int(binary.BigEndian.Uint16(resp[offset+2 : offset+4]))
+		val := resp[offset+4 : offset+4+n]
+		attrs[typ] = val
+		order = append(order, typ)
+
+		switch typ {
+		case stunAttrMessageIntegrity:
+			msg := append([]byte(nil), resp[:offset]...)
+			binary.BigEndian.PutUint16(msg[2:4], uint16(offset-stunHeaderLen+24))
+			mac := hmac.New(sha1.New, []byte(rfc5769Password))
+			mac.Write(msg)
+			if !hmac.Equal(mac.Sum(nil), val) {
+				t.Error("MESSAGE-INTEGRITY mismatch")
+			}
+		case stunAttrFingerprint:
+			want := crc32.ChecksumIEEE(resp[:offset]) ^ stunFingerprintXOR
+			if got := binary.BigEndian.Uint32(val); got != want {
+				t.Errorf("FINGERPRINT = %#x, want %#x", got, want)
+			}
+		}
+		offset += 4 + (n+3)&^3
+	}
+	if offset != len(resp) {
+		t.Errorf("trailing bytes after attributes")
+	}
+
+	want := []uint16{stunAttrXORMappedAddress, stunAttrMessageIntegrity, stunAttrFingerprint}
+	if len(order) != len(want) {
+		t.Fatalf("attributes = %#04x, want %#04x", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("attributes = %#04x, want %#04x", order, want)
+		}
+	}
+	return attrs
+}
+
+func TestRunEmptyDatagram(t *testing.T) {
+	lgr := log15.New()
+	lgr.SetHandler(log15.DiscardHandler())
+	srv, err := NewServer(lgr, nil, config.WebRTC{ListenAddr: "127.0.0.1:0", Hosts: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a session that already has a remote address, so the check below
+	// doesn't start a handshake
+	sess := &session{ufrag: "evtj", pwd: rfc5769Password, addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}}
+	srv.byUfrag[sess.ufrag] = sess
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %s", err)
+		}
+	}()
+
+	c, err := net.DialUDP("udp", nil, srv.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Write(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server is still answering after the empty datagram
+	req := unhex(t, rfc5769Request)
+	if _, err := c.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp := make([]byte, 1500)
+	n, err := c.Read(resp)
+	if err != nil {
+		t.Fatalf("no binding response: %s", err)
+	}
+	checkResponse(t, resp[:n], req[8:20])
+}
@@ -0,0 +1,333 @@
+// Package webrtc sends the live stream to browsers over WebRTC for sub
+// second latency. It is a minimal ice-lite implementation: every viewer
+// shares one UDP port, we only offer host candidates and we only send
+// H.264 video. Signaling is WHEP, served by the webserver.
+package webrtc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/pion/dtls/v3/pkg/crypto/selfsign"
+	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/segment"
+)
+
+const (
+	maxSessions = 16
+	// connectTimeout closes sessions that never complete ICE and DTLS.
+	connectTimeout = 30 * time.Second
+	// consentTimeout closes sessions whose browser stopped sending
+	// connectivity checks. Browsers send them every few seconds.
+	consentTimeout = 30 * time.Second
+)
+
+var (
+	ErrTooManySessions = errors.New("too many webrtc sessions")
+	ErrSessionNotFound = errors.New("webrtc session not found")
+)
+
+// Server answers WHEP offers and streams to each connected browser.
+type Server struct {
+	lgr         log15.Logger
+	live        *segment.Live
+	conn        *net.UDPConn
+	hosts       []net.IP
+	port        int
+	cert        tls.Certificate
+	fingerprint string
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	byUfrag  map[string]*session
+	byAddr   map[string]*session
+}
+
+// NewServer listens on conf.ListenAddr for media.
+func NewServer(lgr log15.Logger, live *segment.Live, conf config.WebRTC) (*Server, error) {
+	addr, err := net.ResolveUDPAddr("udp", conf.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve webrtc listen address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := candidateHosts(conf.Hosts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("generate dtls certificate: %w", err)
+	}
+
+	return &Server{
+		lgr:         lgr,
+		live:        live,
+		conn:        conn,
+		hosts:       hosts,
+		port:        conn.LocalAddr().(*net.UDPAddr).Port,
+		cert:        cert,
+		fingerprint: fingerprint(cert.Certificate[0]),
+		sessions:    make(map[string]*session),
+		byUfrag:     make(map[string]*session),
+		byAddr:      make(map[string]*session),
+	}, nil
+}
+
+// candidateHosts parses the configured hosts, defaulting to the addresses
+// of all interfaces.
+func candidateHosts(hosts []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, h := range hosts {
+		ip := net.ParseIP(h)
+		if ip == nil {
+			addrs, err := net.LookupIP(h)
+			if err != nil || len(addrs) == 0 {
+				return nil, fmt.Errorf("webrtc host %q is not an ip or resolvable name", h)
+			}
+			ip = addrs[0]
+		}
+		ips = append(ips, ip)
+	}
+	if len(ips) > 0 {
+		return ips, nil
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var loopback []net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP
+		if ip.IsLoopback() {
+			loopback = append(loopback, ip)
+		} else if ip.IsGlobalUnicast() {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		ips = loopback
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no addresses for webrtc candidates")
+	}
+	return ips, nil
+}
+
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Offer starts a session for a browser's SDP offer and returns the
+// session id and our SDP answer.
+func (s *Server) Offer(sdpOffer string) (string, string, error) {
+	o, err := parseOffer(sdpOffer)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sessions) >= maxSessions {
+		return "", "", ErrTooManySessions
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := &session{
+		srv:      s,
+		id:       randomString(16),
+		ufrag:    randomString(8),
+		pwd:      randomString(24),
+		ssrc:     randomUint32(),
+		offer:    o,
+		created:  time.Now(),
+		dtlsIn:   make(chan []byte, 64),
+		keyframe: make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	s.sessions[sess.id] = sess
+	s.byUfrag[sess.ufrag] = sess
+
+	s.lgr.Info("webrtc_session_new", "id", sess.id)
+
+	return sess.id, o.answer(sess, s.fingerprint, s.hosts, s.port), nil
+}
+
+// Close ends a session.
+func (s *Server) Close(id string) error {
+	s.mu.Lock()
+	sess := s.sessions[id]
+	s.mu.Unlock()
+
+	if sess == nil {
+		return ErrSessionNotFound
+	}
+	s.remove(sess)
+	return nil
+}
+
+func (s *Server) remove(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions[sess.id] != sess {
+		return
+	}
+	delete(s.sessions, sess.id)
+	delete(s.byUfrag, sess.ufrag)
+	for k, v := range s.byAddr {
+		if v == sess {
+			delete(s.byAddr, k)
+		}
+	}
+	sess.cancel()
+	s.lgr.Info("webrtc_session_end", "id", sess.id)
+}
+
+// Run reads from the media port until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.conn.Close()
+	}()
+	go s.reap(ctx)
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		pkt := buf[:n]
+
+		switch {
+		case isSTUN(pkt):
+			s.handleSTUN(pkt, addr)
+		case pkt[0] >= 20 && pkt[0] <= 63:
+			// dtls, see RFC 7983
+			s.mu.Lock()
+			sess := s.byAddr[addr.String()]
+			s.mu.Unlock()
+			if sess != nil {
+				sess.receiveDTLS(append([]byte(nil), pkt...))
+			}
+		case isRTCP(pkt):
+			s.mu.Lock()
+			sess := s.byAddr[addr.String()]
+			s.mu.Unlock()
+			if sess != nil {
+				sess.receiveRTCP(pkt)
+			}
+		}
+	}
+}
+
+// isRTCP reports if pkt is rtcp rather than rtp on a muxed port, see
+// RFC 5761.
+func isRTCP(pkt []byte) bool {
+	return len(pkt) >= 8 && pkt[0] >= 128 && pkt[0] <= 191 && pkt[1] >= 192 && pkt[1] <= 223
+}
+
+func (s *Server) handleSTUN(pkt []byte, addr *net.UDPAddr) {
+	req, err := parseSTUNRequest(pkt)
+	if err != nil {
+		return
+	}
+	localUfrag, _, _ := strings.Cut(req.username, ":")
+
+	s.mu.Lock()
+	sess := s.byUfrag[localUfrag]
+	s.mu.Unlock()
+	if sess == nil || !req.verify(sess.pwd) {
+		return
+	}
+
+	if _, err := s.conn.WriteToUDP(bindingResponse(req, addr, sess.pwd), addr); err != nil {
+		s.lgr.Error("webrtc_stun_write_err", "err", err)
+		return
+	}
+
+	s.mu.Lock()
+	if sess.checked(addr, req.useCandidate) {
+		s.byAddr[addr.String()] = sess
+	}
+	s.mu.Unlock()
+}
+
+// reap closes sessions that never connected or whose browser went away.
+func (s *Server) reap(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var stale []*session
+		s.mu.Lock()
+		for _, sess := range s.sessions {
+			if sess.stale() {
+				stale = append(stale, sess)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, sess := range stale {
+			s.remove(sess)
+		}
+	}
+}
+
+func randomString(n int) string {
+	b := make([]byte, (n+1)/2)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)[:n]
+}
+
+func randomUint32() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
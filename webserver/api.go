@@ -0,0 +1,332 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/psanford/rom-cam/arming"
+	"github.com/psanford/rom-cam/event"
+	"github.com/psanford/rom-cam/segment"
+)
+
+// apiPrefix is the root of the versioned json api. Breaking changes get a
+// new version rather than changing v1.
+const apiPrefix = "/api/v1/"
+
+// Controller lets the api act on the capture pipeline.
+type Controller interface {
+	DetectorStats() DetectorStats
+	// RecordNext records the next completed segment as an event even if
+	// it has no motion.
+	RecordNext()
+	// ResetCapture restarts the capture ffmpeg process.
+	ResetCapture()
+}
+
+// DetectorStats summarizes motion detection since boot.
+type DetectorStats struct {
+	// Segments is the number of segments checked for motion.
+	Segments int `json:"segments"`
+	// MotionSegments is how many of those had motion.
+	MotionSegments int `json:"motion_segments"`
+	// Errors is how many checks failed and reset the capture.
+	Errors int `json:"errors"`
+	// Threshold is the frame diff that counts as motion.
+	Threshold int `json:"threshold"`
+
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	// LastCheckSeconds is how long the last check took.
+	LastCheckSeconds float64 `json:"last_check_seconds"`
+	// AvgCheckSeconds is the mean check time over every segment.
+	AvgCheckSeconds float64 `json:"avg_check_seconds"`
+	// LastMotionFrames and LastMaxDiff are from the last checked segment.
+	LastMotionFrames int        `json:"last_motion_frames"`
+	LastMaxDiff      int        `json:"last_max_diff"`
+	LastMotion       *time.Time `json:"last_motion,omitempty"`
+}
+
+type apiRingSegment struct {
+	ID            string    `json:"id"`
+	Seq           int       `json:"seq"`
+	TS            time.Time `json:"ts"`
+	Duration      float64   `json:"duration"`
+	Frames        int       `json:"frames"`
+	Bytes         int       `json:"bytes"`
+	Discontinuity bool      `json:"discontinuity,omitempty"`
+	URL           string    `json:"url"`
+}
+
+type apiArming struct {
+	State arming.State `json:"state"`
+	Home  bool         `json:"home"`
+	// PresenceEndpoint reports if POST /presence is enabled.
+	PresenceEndpoint bool `json:"presence_endpoint"`
+}
+
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc(apiPrefix+"status", s.apiGet(s.apiStatusHandler))
+	mux.HandleFunc(apiPrefix+"events", s.apiGet(s.apiEventsHandler))
+	mux.HandleFunc(apiPrefix+"ring", s.apiGet(s.apiRingHandler))
+
+	if s.opts.Config != nil {
+		mux.HandleFunc(apiPrefix+"config", s.apiGet(s.apiConfigHandler))
+	}
+
+	if s.opts.Arming != nil {
+		mux.HandleFunc(apiPrefix+"arming", s.apiArmingHandler)
+	}
+
+	if s.opts.Control != nil {
+		mux.HandleFunc(apiPrefix+"detector", s.apiGet(s.apiDetectorHandler))
+		mux.HandleFunc(apiPrefix+"record", s.apiPost(s.apiRecordHandler))
+		mux.HandleFunc(apiPrefix+"reset", s.apiPost(s.apiResetHandler))
+	}
+
+	if s.opts.Feed != nil {
+		mux.HandleFunc(apiPrefix+"stream", s.streamHandler)
+	}
+
+	if s.opts.MJPEG != nil {
+		mux.HandleFunc(apiPrefix+"snapshot", s.apiPost(s.apiSnapshotHandler))
+	}
+
+	mux.HandleFunc(apiPrefix, func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, "Not found", 404)
+	})
+}
+
+// apiGet wraps a read only endpoint.
+func (s *Server) apiGet(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET")
+			apiError(w, "Method not allowed", 405)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// apiPost wraps an action endpoint. Actions have no body, so nothing
+// stops a plain html form on another site from posting to them; the
+// browser's fetch metadata is checked instead.
+func (s *Server) apiPost(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			apiError(w, "Method not allowed", 405)
+			return
+		}
+		if crossSite(r) {
+			apiError(w, "Forbidden cross-site request", 403)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, s.status())
+}
+
+// apiEventsHandler returns the events recorded since boot, newest first.
+// ?limit=n returns at most n events.
+func (s *Server) apiEventsHandler(w http.ResponseWriter, r *http.Request) {
+	events := []event.Event{}
+	if s.opts.Status != nil {
+		if recent := s.opts.Status.RecentEvents(); recent != nil {
+			events = recent
+		}
+	}
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			apiError(w, "Bad request invalid limit", 400)
+			return
+		}
+		if limit < len(events) {
+			events = events[:limit]
+		}
+	}
+
+	writeJSON(w, 200, events)
+}
+
+// apiRingHandler lists the segments in the ring, oldest first.
+func (s *Server) apiRingHandler(w http.ResponseWriter, r *http.Request) {
+	win := s.ring.Window()
+	out := struct {
+		Stats            segment.RingStats `json:"stats"`
+		DiscontinuitySeq int               `json:"discontinuity_seq"`
+		Segments         []apiRingSegment  `json:"segments"`
+	}{
+		Stats:            s.ring.Stats(),
+		DiscontinuitySeq: win.DiscontinuitySeq,
+		Segments:         make([]apiRingSegment, 0, len(win.Segments)),
+	}
+	for _, seg := range win.Segments {
+		out.Segments = append(out.Segments, apiRingSegment{
+			ID:            seg.ID,
+			Seq:           seg.Seq,
+			TS:            seg.TS,
+			Duration:      seg.Duration.Seconds(),
+			Frames:        seg.Frames,
+			Bytes:         len(seg.Data),
+			Discontinuity: seg.Discontinuity,
+			URL:           "/segment/" + seg.ID + ".ts",
+		})
+	}
+	writeJSON(w, 200, out)
+}
+
+// apiConfigHandler returns the running config with secrets redacted. Keys
+// match the config file.
+func (s *Server) apiConfigHandler(w http.ResponseWriter, r *http.Request) {
+	// round trip through toml so the keys are the ones users know from
+	// their config file
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(s.opts.Config.Redacted()); err != nil {
+		s.lgr.Error("api_encode_config_err", "err", err)
+		apiError(w, "Internal server error", 500)
+		return
+	}
+	conf := make(map[string]interface{})
+	if _, err := toml.Decode(buf.String(), &conf); err != nil {
+		s.lgr.Error("api_decode_config_err", "err", err)
+		apiError(w, "Internal server error", 500)
+		return
+	}
+	writeJSON(w, 200, conf)
+}
+
+// apiArmingHandler reports the arming and presence state. POST sets a
+// manual override with a json body:
+//
+//	{"mode": "armed", "for": "2h"}  override until the duration passes
+//	{"mode": "disarmed"}            override until changed
+//	{"mode": "auto"}                clear the override
+func (s *Server) apiArmingHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "POST":
+		if crossSite(r) {
+			apiError(w, "Forbidden cross-site request", 403)
+			return
+		}
+		var req struct {
+			Mode string `json:"mode"`
+			For  string `json:"for"`
+		}
+		if !readJSON(w, r, &req) {
+			return
+		}
+
+		if req.Mode == "auto" {
+			s.lgr.Info("arming_override_cleared", "remote_addr", r.RemoteAddr)
+			s.opts.Arming.ClearOverride()
+			break
+		}
+
+		mode, err := arming.ParseMode(req.Mode)
+		if err != nil {
+			apiError(w, err.Error(), 400)
+			return
+		}
+
+		var d time.Duration
+		if req.For != "" {
+			d, err = time.ParseDuration(req.For)
+			if err != nil || d < 0 {
+				apiError(w, "Bad request invalid duration", 400)
+				return
+			}
+		}
+
+		s.lgr.Info("arming_override", "mode", mode, "for", d, "remote_addr", r.RemoteAddr)
+		s.opts.Arming.Override(mode, d, "api")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		apiError(w, "Method not allowed", 405)
+		return
+	}
+
+	writeJSON(w, 200, apiArming{
+		State:            s.opts.Arming.State(),
+		Home:             s.opts.Arming.Home(),
+		PresenceEndpoint: s.opts.Presence != nil,
+	})
+}
+
+func (s *Server) apiDetectorHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, s.opts.Control.DetectorStats())
+}
+
+// apiRecordHandler records the segment being captured now as an event.
+// The event is created once the segment is complete, so this returns
+// before it exists.
+func (s *Server) apiRecordHandler(w http.ResponseWriter, r *http.Request) {
+	s.lgr.Info("api_manual_record", "remote_addr", r.RemoteAddr)
+	s.opts.Control.RecordNext()
+	writeJSON(w, 202, map[string]string{"status": "recording"})
+}
+
+// apiResetHandler restarts capture, the same as when motion detection
+// fails.
+func (s *Server) apiResetHandler(w http.ResponseWriter, r *http.Request) {
+	s.lgr.Info("api_reset_capture", "remote_addr", r.RemoteAddr)
+	s.opts.Control.ResetCapture()
+	writeJSON(w, 202, map[string]string{"status": "resetting"})
+}
+
+// apiSnapshotHandler decodes a fresh frame and returns it as a jpeg.
+func (s *Server) apiSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	frame, err := s.opts.MJPEG.Snapshot(ctx)
+	if err != nil {
+		s.lgr.Error("api_snapshot_err", "err", err)
+		apiError(w, "Snapshot not available", 503)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(frame)
+}
+
+// readJSON decodes a json request body into v. It writes an error
+// response and returns false if it can't.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		// also keeps plain html forms on other sites from posting here
+		apiError(w, "Unsupported media type, expected application/json", 415)
+		return false
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		apiError(w, "Bad request "+err.Error(), 400)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, msg string, code int) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
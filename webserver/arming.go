@@ -0,0 +1,56 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/psanford/rom-cam/arming"
+)
+
+// armingHandler reports the current arming state. POST sets a manual
+// override:
+//
+//	mode=armed&for=2h  override until the duration passes
+//	mode=disarmed      override until changed
+//	mode=auto          clear the override
+func (s *Server) armingHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "POST":
+		if crossSite(r) {
+			http.Error(w, "Forbidden cross-site request", 403)
+			return
+		}
+		modeStr := r.FormValue("mode")
+		if modeStr == "auto" {
+			s.opts.Arming.ClearOverride()
+			break
+		}
+
+		mode, err := arming.ParseMode(modeStr)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		var d time.Duration
+		if forStr := r.FormValue("for"); forStr != "" {
+			d, err = time.ParseDuration(forStr)
+			if err != nil || d < 0 {
+				http.Error(w, "Bad request invalid duration", 400)
+				return
+			}
+		}
+
+		s.lgr.Info("arming_override", "mode", mode, "for", d, "remote_addr", r.RemoteAddr)
+		s.opts.Arming.Override(mode, d, "http")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.opts.Arming.State())
+}
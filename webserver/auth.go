@@ -0,0 +1,369 @@
+package webserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/psanford/rom-cam/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie = "rom_cam_session"
+	shareCookie   = "rom_cam_share"
+	shareParam    = "share"
+)
+
+// authenticator protects the webserver with basic auth, signed session
+// cookies and read-only share tokens.
+type authenticator struct {
+	users      map[string]string
+	secret     []byte
+	sessionTTL time.Duration
+	creds      *credCache
+	limiter    *failureLimiter
+}
+
+func newAuthenticator(conf config.WebAuth) (*authenticator, error) {
+	a := &authenticator{
+		users:      conf.Users,
+		sessionTTL: conf.SessionTTL,
+	}
+
+	for user, hash := range conf.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("web_auth user %s: invalid bcrypt hash: %w", user, err)
+		}
+	}
+
+	if conf.SessionSecret != "" {
+		a.secret = []byte(conf.SessionSecret)
+	} else {
+		// sessions and share links won't survive a restart
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.sessionTTL == 0 {
+		a.sessionTTL = 30 * 24 * time.Hour
+	}
+
+	a.creds = newCredCache(a.secret)
+	a.limiter = newFailureLimiter()
+
+	return a, nil
+}
+
+// publicPaths don't require auth. /presence checks its own token.
+var publicPaths = map[string]bool{
+	"/login":    true,
+	"/presence": true,
+}
+
+// sharePaths are the read-only live view paths a share token grants
+// access to. Entries ending in / match by prefix.
+var sharePaths = []string{
+	"/",
+	"/playlist.m3u8",
+	"/dvr.m3u8",
+	"/ll.m3u8",
+	"/ll/",
+	"/snapshot.jpg",
+	"/mjpeg",
+	"/whep",
+	"/whep/",
+	"/segment/",
+	"/static/",
+}
+
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.userFromRequest(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if isSharePath(r.URL.Path) {
+			token := r.URL.Query().Get(shareParam)
+			if subject, expires := a.verifyExpiry("share", token); token != "" && subject != "" {
+				// remember the token so the player's playlist and
+				// segment requests are authorized too
+				http.SetCookie(w, &http.Cookie{
+					Name:     shareCookie,
+					Value:    token,
+					Path:     "/",
+					Expires:  expires,
+					HttpOnly: true,
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteLaxMode,
+				})
+				next.ServeHTTP(w, r)
+				return
+			}
+			if c, err := r.Cookie(shareCookie); err == nil && a.verify("share", c.Value) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if r.Method == "GET" && r.URL.Path == "/" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		if _, _, ok := r.BasicAuth(); ok {
+			if blocked, left := a.limiter.blocked(remoteHost(r)); blocked {
+				tooManyAttempts(w, left)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="rom-cam"`)
+		http.Error(w, "Unauthorized", 401)
+	})
+}
+
+func tooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	http.Error(w, "Too many failed login attempts", 429)
+}
+
+// crossSite reports if a browser sent r from another site. Browsers send
+// the session cookie and cached basic auth credentials with cross-site
+// form posts, so state changing requests must be rejected when this is
+// true. Requests from non-browser clients have neither header and are
+// allowed.
+func crossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "":
+	case "same-origin", "none":
+		return false
+	default:
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+func isSharePath(p string) bool {
+	for _, sp := range sharePaths {
+		if p == sp || (strings.HasSuffix(sp, "/") && sp != "/" && strings.HasPrefix(p, sp)) {
+			return true
+		}
+	}
+	return false
+}
+
+// userFromRequest returns the authenticated user, or "" if the request
+// is not authenticated.
+func (a *authenticator) userFromRequest(r *http.Request) string {
+	if user, pass, ok := r.BasicAuth(); ok && a.checkCredentials(r, user, pass) {
+		return user
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return a.verify("session", c.Value)
+	}
+	return ""
+}
+
+// checkCredentials checks a username and password. Credentials verified
+// in the last few minutes skip bcrypt, and addresses with too many recent
+// failures are refused without checking.
+func (a *authenticator) checkCredentials(r *http.Request, user, pass string) bool {
+	if a.creds.get(user, pass) {
+		return true
+	}
+	addr := remoteHost(r)
+	if blocked, _ := a.limiter.blocked(addr); blocked {
+		return false
+	}
+	if !a.checkPassword(user, pass) {
+		a.limiter.fail(addr)
+		return false
+	}
+	a.creds.add(user, pass)
+	return true
+}
+
+var dummyHash = []byte("$2a$10$wP8ObGUJi/eL.j8tFLzUcevK0VEY4tROn7P6G/nZJwWnjAeM61QQC")
+
+func (a *authenticator) checkPassword(user, pass string) bool {
+	hash, ok := a.users[user]
+	if !ok {
+		// spend the same time as a real check so usernames can't be probed
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}
+
+// sign returns a token of the form base64(subject|expires).base64(mac).
+// The kind is mixed into the mac so session and share tokens are not
+// interchangeable.
+func (a *authenticator) sign(kind, subject string, expires time.Time) string {
+	payload := subject + "|" + strconv.FormatInt(expires.Unix(), 10)
+	enc := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return enc + "." + base64.RawURLEncoding.EncodeToString(a.mac(kind, enc))
+}
+
+// verify checks a token created by sign and returns its subject, or ""
+// if the token is invalid or expired.
+func (a *authenticator) verify(kind, token string) string {
+	subject, _ := a.verifyExpiry(kind, token)
+	return subject
+}
+
+// verifyExpiry is verify but also returns when the token expires.
+func (a *authenticator) verifyExpiry(kind, token string) (string, time.Time) {
+	enc, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", time.Time{}
+	}
+	gotMAC, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotMAC, a.mac(kind, enc)) {
+		return "", time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return "", time.Time{}
+	}
+	sep := strings.LastIndexByte(string(payload), '|')
+	if sep < 0 {
+		return "", time.Time{}
+	}
+	subject, expStr := string(payload[:sep]), string(payload[sep+1:])
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", time.Time{}
+	}
+	return subject, time.Unix(exp, 0)
+}
+
+func (a *authenticator) mac(kind, msg string) []byte {
+	m := hmac.New(sha256.New, a.secret)
+	m.Write([]byte(kind))
+	m.Write([]byte{0})
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+var loginTmpl = template.Must(template.New("login").Parse(`<!doctype html>
+<html>
+  <head><title>rom-cam login</title></head>
+  <body>
+    {{if .}}<p>{{.}}</p>{{end}}
+    <form method="POST" action="/login">
+      <input name="username" placeholder="username" autocomplete="username">
+      <input name="password" type="password" placeholder="password" autocomplete="current-password">
+      <button type="submit">Log in</button>
+    </form>
+  </body>
+</html>
+`))
+
+func (a *authenticator) loginHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+
+	if r.Method != "POST" {
+		loginTmpl.Execute(w, "")
+		return
+	}
+
+	if blocked, left := a.limiter.blocked(remoteHost(r)); blocked {
+		tooManyAttempts(w, left)
+		return
+	}
+
+	user := r.FormValue("username")
+	if !a.checkCredentials(r, user, r.FormValue("password")) {
+		w.WriteHeader(401)
+		loginTmpl.Execute(w, "Invalid username or password")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    a.sign("session", user, time.Now().Add(a.sessionTTL)),
+		Path:     "/",
+		MaxAge:   int(a.sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (a *authenticator) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   sessionCookie,
+		Path:   "/",
+		MaxAge: -1,
+	})
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// shareHandler creates a read-only link to the live view:
+//
+//	POST /share for=24h
+func (a *authenticator) shareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	if crossSite(r) {
+		http.Error(w, "Forbidden cross-site request", 403)
+		return
+	}
+
+	d := 24 * time.Hour
+	if forStr := r.FormValue("for"); forStr != "" {
+		var err error
+		d, err = time.ParseDuration(forStr)
+		if err != nil || d <= 0 {
+			http.Error(w, "Bad request invalid duration", 400)
+			return
+		}
+	}
+
+	expires := time.Now().Add(d)
+	token := a.sign("share", a.userFromRequest(r), expires)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		URL     string    `json:"url"`
+		Token   string    `json:"token"`
+		Expires time.Time `json:"expires"`
+	}{
+		URL:     fmt.Sprintf("%s://%s/?%s=%s", scheme, r.Host, shareParam, token),
+		Token:   token,
+		Expires: expires,
+	})
+}
@@ -0,0 +1,149 @@
+package webserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// credCacheTTL is how long verified basic auth credentials skip
+	// bcrypt. Players and scrapers send them on every request.
+	credCacheTTL = 5 * time.Minute
+	// credCacheMax bounds the cache; it is cleared when full.
+	credCacheMax = 256
+
+	// maxAuthFailures failed logins from one address within
+	// authFailureWindow block further attempts from it until the window
+	// ends, so bogus credentials can't be used to keep bcrypt busy.
+	maxAuthFailures   = 5
+	authFailureWindow = time.Minute
+)
+
+// credCache remembers recently verified basic auth credentials. Entries
+// are keyed by an HMAC of the credentials so passwords aren't kept in
+// memory.
+type credCache struct {
+	secret []byte
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]cachedCred
+}
+
+type cachedCred struct {
+	user    string
+	expires time.Time
+}
+
+func newCredCache(secret []byte) *credCache {
+	return &credCache{
+		secret:  secret,
+		entries: make(map[[sha256.Size]byte]cachedCred),
+	}
+}
+
+func (c *credCache) key(user, pass string) [sha256.Size]byte {
+	m := hmac.New(sha256.New, c.secret)
+	m.Write([]byte("basic"))
+	m.Write([]byte{0})
+	m.Write([]byte(user))
+	m.Write([]byte{0})
+	m.Write([]byte(pass))
+	var k [sha256.Size]byte
+	copy(k[:], m.Sum(nil))
+	return k
+}
+
+// get reports if user and pass were verified within credCacheTTL.
+func (c *credCache) get(user, pass string) bool {
+	k := c.key(user, pass)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[k]
+	if !ok {
+		return false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, k)
+		return false
+	}
+	return e.user == user
+}
+
+func (c *credCache) add(user, pass string) {
+	k := c.key(user, pass)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= credCacheMax {
+		c.entries = make(map[[sha256.Size]byte]cachedCred)
+	}
+	c.entries[k] = cachedCred{
+		user:    user,
+		expires: time.Now().Add(credCacheTTL),
+	}
+}
+
+// failureLimiter counts failed logins per remote address.
+type failureLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*failures
+}
+
+type failures struct {
+	count int
+	start time.Time
+}
+
+func newFailureLimiter() *failureLimiter {
+	return &failureLimiter{
+		failures: make(map[string]*failures),
+	}
+}
+
+// blocked reports if addr has failed too often recently, and for how
+// much longer it is blocked.
+func (l *failureLimiter) blocked(addr string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f := l.failures[addr]
+	if f == nil {
+		return false, 0
+	}
+	left := authFailureWindow - time.Since(f.start)
+	if left <= 0 {
+		delete(l.failures, addr)
+		return false, 0
+	}
+	return f.count >= maxAuthFailures, left
+}
+
+func (l *failureLimiter) fail(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for a, f := range l.failures {
+		if now.Sub(f.start) > authFailureWindow {
+			delete(l.failures, a)
+		}
+	}
+
+	f := l.failures[addr]
+	if f == nil {
+		f = &failures{start: now}
+		l.failures[addr] = f
+	}
+	f.count++
+}
+
+// remoteHost is the client address used for rate limiting.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,121 @@
+package webserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/psanford/rom-cam/event"
+	"github.com/psanford/rom-cam/feed"
+	"github.com/psanford/rom-cam/storage"
+)
+
+// eventIndexRefresh is how often the index is rebuilt from storage. That
+// picks up events from other cameras sharing the bucket and drops records
+// removed by retention.
+const eventIndexRefresh = 15 * time.Minute
+
+// eventIndex holds the keys of the event records in storage so listing
+// events doesn't list the bucket on every request. It is loaded at boot
+// and extended with events as they are recorded.
+type eventIndex struct {
+	lgr   log15.Logger
+	store storage.Backend
+
+	mu      sync.Mutex
+	loaded  bool
+	entries map[string]eventCandidate
+}
+
+func newEventIndex(lgr log15.Logger, store storage.Backend) *eventIndex {
+	return &eventIndex{
+		lgr:     lgr,
+		store:   store,
+		entries: make(map[string]eventCandidate),
+	}
+}
+
+// run loads the index and keeps it up to date until ctx is done. Events
+// published to f are added as they are recorded.
+func (x *eventIndex) run(ctx context.Context, f *feed.Feed) {
+	var msgs <-chan feed.Message
+	if f != nil {
+		var unsubscribe func()
+		msgs, unsubscribe = f.Subscribe(64)
+		defer unsubscribe()
+	}
+
+	ticker := time.NewTicker(eventIndexRefresh)
+	defer ticker.Stop()
+
+	for {
+		if err := x.load(ctx); err != nil {
+			x.lgr.Error("event_index_load_err", "err", err)
+		}
+
+	WAIT:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				break WAIT
+			case msg := <-msgs:
+				if ev, ok := msg.Data.(event.Event); ok && msg.Type == feed.TypeEvent {
+					x.add(ev)
+				}
+			}
+		}
+	}
+}
+
+func (x *eventIndex) load(ctx context.Context) error {
+	objs, err := x.store.List(ctx, storage.KindEvent+"/")
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]eventCandidate, len(objs))
+	for _, obj := range objs {
+		kind, camera, ts, ok := storage.ParseKey(obj.Key)
+		if !ok || kind != storage.KindEvent {
+			continue
+		}
+		entries[obj.Key] = eventCandidate{camera: camera, ts: ts, key: obj.Key}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	// keep events added while listing
+	for key, c := range x.entries {
+		if _, ok := entries[key]; !ok && time.Since(c.ts) < eventIndexRefresh {
+			entries[key] = c
+		}
+	}
+	x.entries = entries
+	x.loaded = true
+	return nil
+}
+
+func (x *eventIndex) add(ev event.Event) {
+	key := storage.Key(storage.KindEvent, ev.Camera, ev.TS)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.entries[key] = eventCandidate{camera: ev.Camera, ts: ev.TS, key: key}
+}
+
+// candidates returns the indexed events. ok is false until the index has
+// loaded.
+func (x *eventIndex) candidates() ([]eventCandidate, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.loaded {
+		return nil, false
+	}
+	out := make([]eventCandidate, 0, len(x.entries))
+	for _, c := range x.entries {
+		out = append(out, c)
+	}
+	return out, true
+}
@@ -0,0 +1,380 @@
+package webserver
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/psanford/rom-cam/event"
+	"github.com/psanford/rom-cam/storage"
+)
+
+const (
+	defaultEventsPage = 50
+	maxEventsPage     = 200
+
+	// maxCachedEvents bounds the cache of event records fetched from
+	// storage. Records never change once written.
+	maxCachedEvents = 10000
+)
+
+//go:embed events.html
+var eventsHTML []byte
+
+func (s *Server) eventsPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	w.Write(eventsHTML)
+}
+
+type eventsResponse struct {
+	Events []eventView `json:"events"`
+	// Cameras are the cameras seen while answering the query.
+	Cameras []string `json:"cameras"`
+	// Before is the value to pass as before= for the next page. It is
+	// empty on the last page.
+	Before string `json:"before,omitempty"`
+}
+
+type eventView struct {
+	event.Event
+	TiledURL    string `json:"tiled_url,omitempty"`
+	ClipURL     string `json:"clip_url,omitempty"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+type eventsQuery struct {
+	camera string
+	zone   string
+	// from and to bound the event time, to is exclusive. Either may be
+	// zero.
+	from, to time.Time
+	limit    int
+}
+
+// eventCandidate is an event found in memory or a key for an event
+// record in storage that hasn't been fetched yet.
+type eventCandidate struct {
+	camera string
+	ts     time.Time
+	ev     *event.Event
+	key    string
+}
+
+// eventsJSONHandler lists motion events newest first, merging the events
+// held in memory with the event records in storage:
+//
+//	GET /events.json?camera=front_door&from=2023-01-02&to=2023-01-03&zone=porch&limit=50&before=1672700000
+//
+// from and to are dates in the camera's timezone and are inclusive.
+// before is the unix timestamp returned by the previous page.
+func (s *Server) eventsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := s.parseEventsQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	resp, err := s.listEvents(r, q)
+	if err != nil {
+		s.lgr.Error("list_events_err", "err", err)
+		http.Error(w, "List events failed", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) parseEventsQuery(r *http.Request) (eventsQuery, error) {
+	loc := s.opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	q := eventsQuery{
+		camera: r.FormValue("camera"),
+		zone:   r.FormValue("zone"),
+		limit:  defaultEventsPage,
+	}
+
+	if strings.Contains(q.camera, "/") {
+		return q, fmt.Errorf("Bad request invalid camera")
+	}
+
+	if fromStr := r.FormValue("from"); fromStr != "" {
+		from, err := time.ParseInLocation("2006-01-02", fromStr, loc)
+		if err != nil {
+			return q, fmt.Errorf("Bad request invalid from date")
+		}
+		q.from = from
+	}
+
+	if toStr := r.FormValue("to"); toStr != "" {
+		to, err := time.ParseInLocation("2006-01-02", toStr, loc)
+		if err != nil {
+			return q, fmt.Errorf("Bad request invalid to date")
+		}
+		q.to = to.AddDate(0, 0, 1)
+	}
+
+	if beforeStr := r.FormValue("before"); beforeStr != "" {
+		before, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("Bad request invalid before")
+		}
+		if q.to.IsZero() || time.Unix(before, 0).Before(q.to) {
+			q.to = time.Unix(before, 0)
+		}
+	}
+
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("Bad request invalid limit")
+		}
+		if limit > maxEventsPage {
+			limit = maxEventsPage
+		}
+		q.limit = limit
+	}
+
+	return q, nil
+}
+
+func (q eventsQuery) inRange(ts time.Time) bool {
+	if !q.from.IsZero() && ts.Before(q.from) {
+		return false
+	}
+	if !q.to.IsZero() && !ts.Before(q.to) {
+		return false
+	}
+	return true
+}
+
+func (s *Server) listEvents(r *http.Request, q eventsQuery) (*eventsResponse, error) {
+	var (
+		candidates []eventCandidate
+		seen       = make(map[string]bool)
+		cameras    = make(map[string]bool)
+	)
+
+	add := func(c eventCandidate) {
+		cameras[c.camera] = true
+		if q.camera != "" && c.camera != q.camera {
+			return
+		}
+		if !q.inRange(c.ts) {
+			return
+		}
+		id := fmt.Sprintf("%s/%d", c.camera, c.ts.Unix())
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		candidates = append(candidates, c)
+	}
+
+	if s.opts.Status != nil {
+		for _, ev := range s.opts.Status.RecentEvents() {
+			ev := ev
+			add(eventCandidate{camera: ev.Camera, ts: ev.TS, ev: &ev})
+		}
+	}
+
+	if indexed, ok := s.indexedEvents(); ok {
+		for _, c := range indexed {
+			add(c)
+		}
+	} else if s.opts.Store != nil {
+		// the index is still loading
+		objs, err := s.opts.Store.List(r.Context(), eventsListPrefix(q))
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			kind, camera, ts, ok := storage.ParseKey(obj.Key)
+			if !ok || kind != storage.KindEvent {
+				continue
+			}
+			add(eventCandidate{camera: camera, ts: ts, key: obj.Key})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].ts.After(candidates[j].ts)
+	})
+
+	resp := &eventsResponse{
+		Events: []eventView{},
+	}
+
+	for len(candidates) > 0 && len(resp.Events) < q.limit {
+		batch := candidates
+		if len(batch) > q.limit {
+			batch = batch[:q.limit]
+		}
+		candidates = candidates[len(batch):]
+
+		s.loadEvents(r, batch)
+
+		for i, c := range batch {
+			if c.ev == nil {
+				continue
+			}
+			if q.zone != "" && !hasZone(c.ev.Zones, q.zone) {
+				continue
+			}
+			resp.Events = append(resp.Events, s.eventView(*c.ev))
+			if len(resp.Events) == q.limit {
+				if i < len(batch)-1 || len(candidates) > 0 {
+					resp.Before = strconv.FormatInt(c.ts.Unix(), 10)
+				}
+				break
+			}
+		}
+	}
+
+	for camera := range cameras {
+		resp.Cameras = append(resp.Cameras, camera)
+	}
+	sort.Strings(resp.Cameras)
+
+	return resp, nil
+}
+
+func (s *Server) indexedEvents() ([]eventCandidate, bool) {
+	if s.events == nil {
+		return nil, false
+	}
+	return s.events.candidates()
+}
+
+// eventsListPrefix narrows the storage listing as much as the query
+// allows. Keys end in the unix timestamp so a time range can be turned
+// into the common prefix of its bounds.
+func eventsListPrefix(q eventsQuery) string {
+	if q.camera == "" {
+		return storage.KindEvent + "/"
+	}
+	prefix := storage.Prefix(storage.KindEvent, q.camera)
+	if q.from.IsZero() || q.to.IsZero() {
+		return prefix
+	}
+
+	from := strconv.FormatInt(q.from.Unix(), 10)
+	to := strconv.FormatInt(q.to.Unix(), 10)
+	if len(from) != len(to) {
+		return prefix
+	}
+	i := 0
+	for i < len(from) && from[i] == to[i] {
+		i++
+	}
+	return prefix + from[:i]
+}
+
+// loadEvents fetches the event records for candidates that only have a
+// storage key. Candidates that fail to load are left with a nil ev.
+func (s *Server) loadEvents(r *http.Request, candidates []eventCandidate) {
+	var wg sync.WaitGroup
+	for i := range candidates {
+		c := &candidates[i]
+		if c.ev != nil {
+			continue
+		}
+
+		s.eventCacheMu.Lock()
+		ev, ok := s.eventCache[c.key]
+		s.eventCacheMu.Unlock()
+		if ok {
+			c.ev = &ev
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := s.opts.Store.Get(r.Context(), c.key)
+			if err != nil {
+				s.lgr.Error("get_event_err", "key", c.key, "err", err)
+				return
+			}
+			var ev event.Event
+			err = json.Unmarshal(data, &ev)
+			if err != nil {
+				s.lgr.Error("decode_event_err", "key", c.key, "err", err)
+				return
+			}
+			c.ev = &ev
+
+			s.eventCacheMu.Lock()
+			if s.eventCache == nil || len(s.eventCache) >= maxCachedEvents {
+				s.eventCache = make(map[string]event.Event)
+			}
+			s.eventCache[c.key] = ev
+			s.eventCacheMu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+func hasZone(zones []string, zone string) bool {
+	for _, z := range zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) eventView(ev event.Event) eventView {
+	v := eventView{Event: ev}
+	if s.opts.Store == nil {
+		return v
+	}
+	if ev.TiledKey != "" {
+		v.TiledURL = "/media/" + ev.TiledKey
+	}
+	if ev.MP4Key != "" {
+		v.ClipURL = "/media/" + ev.MP4Key
+		v.DownloadURL = v.ClipURL + "?download=1"
+	}
+	return v
+}
+
+// mediaHandler serves uploaded clips and tiled images from storage so the
+// browser doesn't need access to the bucket:
+//
+//	GET /media/mp4/front_door/1672700000.mp4?download=1
+func (s *Server) mediaHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/media/")
+	kind, camera, ts, ok := storage.ParseKey(key)
+	if !ok || (kind != storage.KindMP4 && kind != storage.KindTiled) {
+		http.Error(w, "Bad request invalid key", 400)
+		return
+	}
+
+	data, err := s.opts.Store.Get(r.Context(), key)
+	if err != nil {
+		s.lgr.Error("get_media_err", "key", key, "err", err)
+		http.Error(w, "Media not found", 404)
+		return
+	}
+
+	if r.FormValue("download") != "" {
+		filename := fmt.Sprintf("%s-%s%s", camera, ts.Format("20060102-150405"), path.Ext(key))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	// objects are never rewritten under the same key
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, key, ts, bytes.NewReader(data))
+}
@@ -0,0 +1,110 @@
+//go:build ignore
+
+// gen_hlsjs downloads the pinned hls.js release from the npm registry and
+// writes dist/hls.min.js to static/ so it is embedded in the binary. Run
+// it with go generate ./webserver before building.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	hlsjsVersion = "1.5.13"
+	registry     = "https://registry.npmjs.org/hls.js/"
+	distFile     = "package/dist/hls.min.js"
+	outFile      = "static/hls.min.js"
+)
+
+var client = &http.Client{Timeout: 2 * time.Minute}
+
+func main() {
+	var meta struct {
+		Dist struct {
+			Tarball   string `json:"tarball"`
+			Integrity string `json:"integrity"`
+		} `json:"dist"`
+	}
+	body, err := get(registry + hlsjsVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(body, &meta); err != nil {
+		log.Fatalf("parse registry metadata: %s", err)
+	}
+
+	tarball, err := get(meta.Dist.Tarball)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkIntegrity(tarball, meta.Dist.Integrity); err != nil {
+		log.Fatal(err)
+	}
+
+	js, err := extract(tarball, distFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(outFile, js, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("wrote hls.js %s to %s\n", hlsjsVersion, outFile)
+}
+
+func get(url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// checkIntegrity verifies b against an npm subresource integrity string,
+// e.g. "sha512-<base64>".
+func checkIntegrity(b []byte, integrity string) error {
+	want, ok := strings.CutPrefix(integrity, "sha512-")
+	if !ok {
+		return fmt.Errorf("unsupported integrity %q", integrity)
+	}
+	sum := sha512.Sum512(b)
+	if got := base64.StdEncoding.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("tarball integrity mismatch: got sha512-%s, want %s", got, integrity)
+	}
+	return nil
+}
+
+func extract(tarball []byte, name string) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(tarball))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in tarball", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == name {
+			return io.ReadAll(tr)
+		}
+	}
+}
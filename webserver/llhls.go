@@ -0,0 +1,171 @@
+package webserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/psanford/rom-cam/segment"
+)
+
+// llPlaylistHandler serves a low latency HLS playlist with partial
+// segments. It supports blocking reloads with _HLS_msn and _HLS_part.
+func (s *Server) llPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	live := s.opts.Live
+	win := live.Window()
+
+	if msnStr := r.FormValue("_HLS_msn"); msnStr != "" {
+		msn, err := strconv.Atoi(msnStr)
+		if err != nil || msn < 0 {
+			http.Error(w, "Bad request invalid _HLS_msn", 400)
+			return
+		}
+		part := -1
+		if partStr := r.FormValue("_HLS_part"); partStr != "" {
+			part, err = strconv.Atoi(partStr)
+			if err != nil || part < 0 {
+				http.Error(w, "Bad request invalid _HLS_part", 400)
+				return
+			}
+		}
+
+		if msn > win.NextMSN+1 {
+			http.Error(w, "Bad request _HLS_msn too far in the future", 400)
+			return
+		}
+
+		win, err = live.Wait(r.Context(), msn, part, s.blockTimeout())
+		if errors.Is(err, segment.ErrLiveTimeout) {
+			http.Error(w, "Timeout waiting for segment", 503)
+			return
+		} else if err != nil {
+			return
+		}
+	} else if r.FormValue("_HLS_part") != "" {
+		http.Error(w, "Bad request _HLS_part without _HLS_msn", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(s.encodeLLPlaylist(win, live.PartTarget()))
+}
+
+// blockTimeout is how long to hold a blocking request. The spec suggests
+// three target durations.
+func (s *Server) blockTimeout() time.Duration {
+	return 3 * time.Duration(s.targetDuration()) * time.Second
+}
+
+func (s *Server) encodeLLPlaylist(win segment.LiveWindow, partTarget time.Duration) []byte {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:6\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", s.targetDuration())
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget.Seconds())
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget.Seconds())
+
+	firstMSN := win.NextMSN
+	if len(win.Segments) > 0 {
+		firstMSN = win.Segments[0].MSN
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", firstMSN)
+	if win.DiscontinuitySeq > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", win.DiscontinuitySeq)
+	}
+
+	for _, seg := range win.Segments {
+		if !seg.Complete && len(seg.Parts) == 0 {
+			// only the preload hint is known for a segment that just started
+			continue
+		}
+		if seg.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", seg.TS.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+		for i, p := range seg.Parts {
+			fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"/ll/%d.%d.ts\"", p.Duration.Seconds(), seg.MSN, i)
+			if p.Independent {
+				b.WriteString(",INDEPENDENT=YES")
+			}
+			b.WriteString("\n")
+		}
+		if seg.Complete {
+			fmt.Fprintf(&b, "#EXTINF:%.3f,\n/ll/%d.ts\n", s.clampDuration(seg.Duration), seg.MSN)
+		}
+	}
+
+	fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"/ll/%d.%d.ts\"\n", win.NextMSN, win.NextPart)
+
+	return b.Bytes()
+}
+
+// llSegmentHandler serves complete segments (/ll/<msn>.ts) and parts
+// (/ll/<msn>.<part>.ts) from the low latency window. Requests for the
+// next part block until it is published so preload hints work.
+func (s *Server) llSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/ll/")
+	if !strings.HasSuffix(name, ".ts") {
+		http.Error(w, "Bad request", 400)
+		return
+	}
+	fields := strings.Split(strings.TrimSuffix(name, ".ts"), ".")
+	if len(fields) > 2 {
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	msn, err := strconv.Atoi(fields[0])
+	if err != nil || msn < 0 {
+		http.Error(w, "Bad request invalid sequence number", 400)
+		return
+	}
+	part := -1
+	if len(fields) == 2 {
+		part, err = strconv.Atoi(fields[1])
+		if err != nil || part < 0 {
+			http.Error(w, "Bad request invalid part", 400)
+			return
+		}
+	}
+
+	live := s.opts.Live
+	win := live.Window()
+	if msn > win.NextMSN+1 {
+		http.Error(w, "Segment not found", 404)
+		return
+	}
+
+	win, err = live.Wait(r.Context(), msn, part, s.blockTimeout())
+	if errors.Is(err, segment.ErrLiveTimeout) {
+		http.Error(w, "Timeout waiting for segment", 503)
+		return
+	} else if err != nil {
+		return
+	}
+
+	seg, ok := win.Find(msn)
+	if !ok {
+		http.Error(w, "Segment not found", 404)
+		return
+	}
+
+	var data []byte
+	if part < 0 {
+		data = seg.Data()
+	} else if part < len(seg.Parts) {
+		data = seg.Parts[part].Data
+	} else {
+		// the segment ended before this part was cut
+		http.Error(w, "Part not found", 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Write(data)
+}
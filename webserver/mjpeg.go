@@ -0,0 +1,67 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// snapshotHandler returns the latest decoded frame as a jpeg.
+func (s *Server) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	frame, err := s.opts.MJPEG.Snapshot(ctx)
+	if err != nil {
+		s.lgr.Error("snapshot_err", "err", err)
+		http.Error(w, "Snapshot not available", 503)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(frame)
+}
+
+// mjpegHandler streams jpegs as multipart/x-mixed-replace until the
+// client goes away.
+func (s *Server) mjpegHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+
+	dec := s.opts.MJPEG
+	dec.Acquire()
+	defer dec.Release()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=frame")
+	w.Header().Set("Cache-Control", "no-store")
+
+	var last time.Time
+	for {
+		frame, ts, changed := dec.Frame()
+		if frame != nil && !ts.Equal(last) {
+			last = ts
+			_, err := fmt.Fprintf(w, "--frame\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(frame))
+			if err == nil {
+				_, err = w.Write(frame)
+			}
+			if err == nil {
+				_, err = w.Write([]byte("\r\n"))
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package webserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// presenceHandler lets external systems report that someone is home:
+//
+//	curl -X POST -H "Authorization: Bearer $TOKEN" -d home=true http://cam/presence
+func (s *Server) presenceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.PresenceToken)) != 1 {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+
+	home, err := strconv.ParseBool(r.FormValue("home"))
+	if err != nil {
+		http.Error(w, "Bad request home must be true or false", 400)
+		return
+	}
+
+	s.lgr.Info("presence_endpoint_set", "home", home, "remote_addr", r.RemoteAddr)
+	s.opts.Presence.Set(home)
+	w.WriteHeader(204)
+}
@@ -0,0 +1,437 @@
+(function () {
+    'use strict';
+
+    var video = document.getElementById('video');
+    var mjpeg = document.getElementById('mjpeg');
+    var playerError = document.getElementById('player-error');
+    var timeshiftBtn = document.getElementById('timeshift');
+    var transport = document.getElementById('transport');
+
+    // the live playlist is low latency hls, the dvr playlist has the whole
+    // ring so the player can seek back through it
+    var playlists = {live: '/ll.m3u8', dvr: '/dvr.m3u8'};
+    var current = 'live';
+    var hls = null;
+
+    // live view over webrtc, if the server has it enabled
+    var pc = null;
+    var whepSession = null;
+    var webrtcConnectTimeout = 10000;
+
+    function showPlayerError(msg) {
+        playerError.textContent = msg;
+        playerError.hidden = false;
+    }
+
+    function stopWebRTC() {
+        if (pc) {
+            pc.close();
+            pc = null;
+        }
+        if (whepSession) {
+            fetch(whepSession, {method: 'DELETE', credentials: 'same-origin', keepalive: true})
+                .catch(function () {});
+            whepSession = null;
+        }
+        video.srcObject = null;
+    }
+
+    // startWebRTC negotiates with the server's WHEP endpoint. The promise
+    // rejects if webrtc is disabled or does not connect, so the caller can
+    // fall back to hls.
+    function startWebRTC() {
+        if (!window.RTCPeerConnection) {
+            return Promise.reject(new Error('webrtc not supported'));
+        }
+
+        var conn = new RTCPeerConnection();
+        pc = conn;
+        conn.addTransceiver('video', {direction: 'recvonly'});
+
+        var connected = new Promise(function (resolve, reject) {
+            var timer = setTimeout(function () {
+                reject(new Error('webrtc connect timeout'));
+            }, webrtcConnectTimeout);
+            conn.ontrack = function (ev) {
+                video.srcObject = ev.streams.length ? ev.streams[0] : new MediaStream([ev.track]);
+            };
+            conn.onconnectionstatechange = function () {
+                if (conn.connectionState === 'connected') {
+                    clearTimeout(timer);
+                    resolve();
+                } else if (conn.connectionState === 'failed') {
+                    clearTimeout(timer);
+                    reject(new Error('webrtc connection failed'));
+                    if (pc === conn && current === 'live') {
+                        // lost an established session
+                        console.error('webrtc connection failed, falling back to hls');
+                        stopWebRTC();
+                        startPlayer();
+                    }
+                }
+            };
+        });
+
+        return conn.createOffer()
+            .then(function (offer) {
+                return conn.setLocalDescription(offer);
+            })
+            .then(function () {
+                // the server is ice-lite and sends all of its candidates in
+                // the answer, so there is no need to wait for ours
+                return fetch('/whep', {
+                    method: 'POST',
+                    credentials: 'same-origin',
+                    headers: {'Content-Type': 'application/sdp'},
+                    body: conn.localDescription.sdp
+                });
+            })
+            .then(function (resp) {
+                if (resp.status !== 201) {
+                    throw new Error('whep status ' + resp.status);
+                }
+                whepSession = resp.headers.get('Location');
+                return resp.text();
+            })
+            .then(function (answer) {
+                return conn.setRemoteDescription({type: 'answer', sdp: answer});
+            })
+            .then(function () {
+                return connected;
+            })
+            .then(function () {
+                video.play().catch(function () {});
+            });
+    }
+
+    function startLive() {
+        playerError.hidden = true;
+        startWebRTC()
+            .then(function () {
+                transport.textContent = 'WebRTC';
+            })
+            .catch(function (err) {
+                console.log('webrtc unavailable, using hls:', err.message);
+                stopWebRTC();
+                startPlayer();
+            });
+    }
+
+    function stopMJPEG() {
+        mjpeg.removeAttribute('src');
+        mjpeg.hidden = true;
+        video.hidden = false;
+    }
+
+    // startMJPEG shows the mjpeg stream in place of the video for browsers
+    // without media source extensions, which hls.js needs. There is no
+    // timeshift in this mode.
+    function startMJPEG() {
+        video.hidden = true;
+        mjpeg.hidden = false;
+        mjpeg.onerror = function () {
+            stopMJPEG();
+            showPlayerError('This browser cannot play HLS and the mjpeg stream is not available.');
+        };
+        mjpeg.src = '/mjpeg';
+        transport.textContent = 'MJPEG';
+    }
+
+    function startPlayer() {
+        var src = playlists[current];
+        playerError.hidden = true;
+        transport.textContent = 'HLS';
+        stopMJPEG();
+
+        if (window.Hls && Hls.isSupported()) {
+            if (hls) {
+                hls.destroy();
+            }
+            var player = new Hls({lowLatencyMode: current === 'live'});
+            hls = player;
+            player.on(Hls.Events.ERROR, function (event, data) {
+                if (!data.fatal) {
+                    return;
+                }
+                switch (data.type) {
+                case Hls.ErrorTypes.MEDIA_ERROR:
+                    console.log('fatal media error encountered, try to recover');
+                    player.recoverMediaError();
+                    break;
+                case Hls.ErrorTypes.NETWORK_ERROR:
+                    console.error('fatal network error encountered', data);
+                    // the ring may still be filling up after a restart
+                    setTimeout(function () { player.loadSource(src); }, 5000);
+                    break;
+                default:
+                    console.error('fatal error', data);
+                    player.destroy();
+                    showPlayerError('Playback failed: ' + data.details);
+                    break;
+                }
+            });
+            player.loadSource(src);
+            player.attachMedia(video);
+            return;
+        }
+
+        if (video.canPlayType('application/vnd.apple.mpegurl')) {
+            video.src = src;
+            return;
+        }
+
+        if (!window.Hls) {
+            console.error('hls.js did not load, run go generate ./webserver and rebuild');
+        }
+
+        if (current === 'live') {
+            startMJPEG();
+            return;
+        }
+        showPlayerError('Timeshift needs HLS support, which this browser does not have.');
+    }
+
+    timeshiftBtn.addEventListener('click', function () {
+        current = current === 'live' ? 'dvr' : 'live';
+        timeshiftBtn.textContent = current === 'live' ? 'Timeshift' : 'Back to live';
+        stopWebRTC();
+        if (hls) {
+            hls.destroy();
+            hls = null;
+        }
+        video.removeAttribute('src');
+        if (current === 'live') {
+            startLive();
+        } else {
+            startPlayer();
+        }
+    });
+
+    window.addEventListener('pagehide', function () {
+        stopWebRTC();
+        stopMJPEG();
+    });
+
+    function formatBytes(n) {
+        var units = ['B', 'KB', 'MB', 'GB'];
+        var i = 0;
+        while (n >= 1024 && i < units.length - 1) {
+            n /= 1024;
+            i++;
+        }
+        return n.toFixed(i === 0 ? 0 : 1) + ' ' + units[i];
+    }
+
+    function formatTime(ts) {
+        return new Date(ts).toLocaleString();
+    }
+
+    function renderStatus(st) {
+        if (st.camera) {
+            document.getElementById('camera').textContent = st.camera;
+            document.title = st.camera + ' - rom-cam';
+        }
+
+        setMotion(st.motion);
+
+        if (st.arming) {
+            var mode = st.arming.mode + ' (' + st.arming.reason;
+            if (st.arming.source) {
+                mode += ' by ' + st.arming.source;
+            }
+            mode += ')';
+            if (st.arming.until) {
+                mode += ' until ' + formatTime(st.arming.until);
+            }
+            document.getElementById('mode').textContent = mode;
+        }
+        if (st.home !== undefined) {
+            document.getElementById('presence').textContent = st.home ? 'home' : 'away';
+        }
+        if (st.last_segment) {
+            document.getElementById('last-segment').textContent = formatTime(st.last_segment);
+        }
+
+        if (st.ring) {
+            // durations are nanoseconds
+            var ring = Math.round(st.ring.duration / 1e9) + 's, ' + formatBytes(st.ring.bytes);
+            if (st.ring.max_bytes) {
+                ring += ' of ' + formatBytes(st.ring.max_bytes);
+            }
+            document.getElementById('ring').textContent = ring;
+        }
+
+        var rows = document.getElementById('event-rows');
+        if (st.recent_events.length === 0) {
+            return;
+        }
+        rows.textContent = '';
+        st.recent_events.forEach(function (ev) {
+            var tr = document.createElement('tr');
+            [formatTime(ev.ts), ev.frames, ev.max_diff, ev.mode || ''].forEach(function (v) {
+                var td = document.createElement('td');
+                td.textContent = v;
+                tr.appendChild(td);
+            });
+            rows.appendChild(tr);
+        });
+    }
+
+    function fetchStatus() {
+        return fetch('/status', {credentials: 'same-origin'})
+            .then(function (resp) {
+                if (!resp.ok) {
+                    throw new Error('status ' + resp.status);
+                }
+                return resp.json();
+            })
+            .then(renderStatus)
+            .catch(function (err) {
+                console.error('fetch status err', err);
+            });
+    }
+
+    function pollStatus() {
+        fetchStatus().finally(function () {
+            setTimeout(pollStatus, 5000);
+        });
+    }
+
+    // motion graph, fed by the server-sent event stream
+    var graphSection = document.getElementById('motion-graph');
+    var graph = document.getElementById('graph');
+    var graphWindow = 60 * 1000;
+    var scores = [];
+    var boundaries = [];
+    var threshold = 0;
+    var latest = 0;
+
+    function trimGraph() {
+        var start = latest - graphWindow;
+        while (scores.length && scores[0].t < start) {
+            scores.shift();
+        }
+        while (boundaries.length && boundaries[0] < start) {
+            boundaries.shift();
+        }
+    }
+
+    function drawGraph() {
+        var ratio = window.devicePixelRatio || 1;
+        var w = graph.clientWidth;
+        var h = graph.clientHeight;
+        if (graph.width !== w * ratio || graph.height !== h * ratio) {
+            graph.width = w * ratio;
+            graph.height = h * ratio;
+        }
+        var ctx = graph.getContext('2d');
+        ctx.setTransform(ratio, 0, 0, ratio, 0, 0);
+        ctx.clearRect(0, 0, w, h);
+
+        var max = threshold * 2;
+        scores.forEach(function (s) {
+            max = Math.max(max, s.diff);
+        });
+        if (!max) {
+            return;
+        }
+        var start = latest - graphWindow;
+        var x = function (t) { return (t - start) / graphWindow * w; };
+        var y = function (v) { return h - v / max * (h - 4) - 2; };
+
+        ctx.lineWidth = 1;
+        ctx.strokeStyle = '#bbb';
+        boundaries.forEach(function (t) {
+            ctx.beginPath();
+            ctx.moveTo(x(t), 0);
+            ctx.lineTo(x(t), h);
+            ctx.stroke();
+        });
+
+        if (threshold) {
+            ctx.strokeStyle = '#c62828';
+            ctx.beginPath();
+            ctx.moveTo(0, y(threshold));
+            ctx.lineTo(w, y(threshold));
+            ctx.stroke();
+        }
+
+        ctx.strokeStyle = '#1565c0';
+        ctx.lineWidth = 1.5;
+        ctx.beginPath();
+        scores.forEach(function (s, i) {
+            if (i === 0) {
+                ctx.moveTo(x(s.t), y(s.diff));
+            } else {
+                ctx.lineTo(x(s.t), y(s.diff));
+            }
+        });
+        ctx.stroke();
+    }
+
+    var drawPending = false;
+    function scheduleDraw() {
+        if (drawPending) {
+            return;
+        }
+        drawPending = true;
+        window.requestAnimationFrame(function () {
+            drawPending = false;
+            drawGraph();
+        });
+    }
+
+    function setMotion(active) {
+        var motion = document.getElementById('motion');
+        motion.textContent = active ? 'motion' : 'idle';
+        motion.classList.toggle('active', active);
+    }
+
+    function startStream() {
+        if (!window.EventSource) {
+            return;
+        }
+        var stream = new EventSource('/api/v1/stream');
+        stream.onopen = function () {
+            graphSection.hidden = false;
+        };
+
+        stream.addEventListener('motion_score', function (ev) {
+            var s = JSON.parse(ev.data);
+            var t = Date.parse(s.ts);
+            threshold = s.threshold;
+            latest = Math.max(latest, t);
+            scores.push({t: t, diff: s.diff});
+            trimGraph();
+            scheduleDraw();
+        });
+        stream.addEventListener('segment', function (ev) {
+            var seg = JSON.parse(ev.data);
+            var t = Date.parse(seg.ts);
+            boundaries.push(t);
+            latest = Math.max(latest, t);
+            trimGraph();
+            scheduleDraw();
+        });
+        stream.addEventListener('motion', function (ev) {
+            setMotion(JSON.parse(ev.data).motion);
+        });
+        // refresh everything else on state changes rather than waiting
+        // for the next poll
+        ['event', 'arming', 'presence'].forEach(function (name) {
+            stream.addEventListener(name, function () {
+                fetchStatus();
+            });
+        });
+
+        window.addEventListener('pagehide', function () {
+            stream.close();
+        });
+    }
+
+    window.addEventListener('resize', scheduleDraw);
+
+    startLive();
+    pollStatus();
+    startStream();
+})();
@@ -0,0 +1,158 @@
+(function () {
+    'use strict';
+
+    var form = document.getElementById('filters');
+    var grid = document.getElementById('event-grid');
+    var moreBtn = document.getElementById('more');
+    var empty = document.getElementById('empty');
+    var cameraFilter = document.getElementById('camera-filter');
+    var player = document.getElementById('player');
+    var clip = document.getElementById('clip');
+
+    var knownCameras = {};
+    var before = '';
+
+    function params() {
+        var p = new URLSearchParams(new FormData(form));
+        p.forEach(function (v, k) {
+            if (v === '') {
+                p.delete(k);
+            }
+        });
+        return p;
+    }
+
+    function addCameras(cameras) {
+        cameras.forEach(function (c) {
+            if (knownCameras[c]) {
+                return;
+            }
+            knownCameras[c] = true;
+            var opt = document.createElement('option');
+            opt.value = c;
+            opt.textContent = c;
+            cameraFilter.appendChild(opt);
+        });
+    }
+
+    function el(tag, cls, text) {
+        var e = document.createElement(tag);
+        if (cls) {
+            e.className = cls;
+        }
+        if (text !== undefined) {
+            e.textContent = text;
+        }
+        return e;
+    }
+
+    function play(ev) {
+        clip.src = ev.clip_url;
+        player.hidden = false;
+        clip.play();
+        player.scrollIntoView();
+    }
+
+    function renderEvent(ev) {
+        var card = el('article', 'event-card');
+
+        if (ev.tiled_url) {
+            var img = el('img');
+            img.src = ev.tiled_url;
+            img.loading = 'lazy';
+            img.alt = 'tiled frames';
+            card.appendChild(img);
+        } else {
+            card.appendChild(el('div', 'no-image', 'no image'));
+        }
+
+        card.appendChild(el('h3', '', new Date(ev.ts).toLocaleString()));
+
+        var meta = el('dl');
+        [
+            ['Camera', ev.camera],
+            ['Frames', ev.frames],
+            ['Max diff', ev.max_diff],
+            ['Zones', (ev.zones || []).join(', ') || '-'],
+            ['Mode', ev.mode_reason || ev.mode || '-'],
+        ].forEach(function (kv) {
+            meta.appendChild(el('dt', '', kv[0]));
+            meta.appendChild(el('dd', '', kv[1]));
+        });
+        card.appendChild(meta);
+
+        if (ev.clip_url) {
+            var playBtn = el('button', '', 'Play');
+            playBtn.type = 'button';
+            playBtn.addEventListener('click', function () { play(ev); });
+            card.appendChild(playBtn);
+
+            var dl = el('a', '', 'Download MP4');
+            dl.href = ev.download_url;
+            card.appendChild(dl);
+        }
+
+        grid.appendChild(card);
+    }
+
+    function load(reset) {
+        var p = params();
+        if (reset) {
+            before = '';
+            grid.textContent = '';
+        } else if (before) {
+            p.set('before', before);
+        }
+
+        moreBtn.disabled = true;
+        fetch('/events.json?' + p.toString(), {credentials: 'same-origin'})
+            .then(function (resp) {
+                if (!resp.ok) {
+                    return resp.text().then(function (t) { throw new Error(t); });
+                }
+                return resp.json();
+            })
+            .then(function (data) {
+                addCameras(data.cameras || []);
+                data.events.forEach(renderEvent);
+                before = data.before || '';
+                moreBtn.hidden = !before;
+                empty.hidden = grid.children.length > 0;
+            })
+            .catch(function (err) {
+                console.error('load events err', err);
+                empty.textContent = 'Failed to load events: ' + err.message;
+                empty.hidden = false;
+            })
+            .finally(function () {
+                moreBtn.disabled = false;
+            });
+    }
+
+    form.addEventListener('submit', function (e) {
+        e.preventDefault();
+        history.replaceState(null, '', '?' + params().toString());
+        load(true);
+    });
+
+    moreBtn.addEventListener('click', function () { load(false); });
+
+    document.getElementById('close-player').addEventListener('click', function () {
+        clip.pause();
+        clip.removeAttribute('src');
+        clip.load();
+        player.hidden = true;
+    });
+
+    // restore filters from the url so filtered views can be bookmarked
+    new URLSearchParams(location.search).forEach(function (v, k) {
+        if (k === 'camera') {
+            addCameras([v]);
+        }
+        if (form.elements[k]) {
+            form.elements[k].value = v;
+        }
+    });
+
+    load(true);
+})();
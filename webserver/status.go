@@ -0,0 +1,65 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/psanford/rom-cam/arming"
+	"github.com/psanford/rom-cam/event"
+	"github.com/psanford/rom-cam/segment"
+)
+
+// StatusSource reports live daemon state for the UI.
+type StatusSource interface {
+	Motion() bool
+	// RecentEvents returns the most recent motion events, newest first.
+	RecentEvents() []event.Event
+}
+
+type status struct {
+	Camera       string            `json:"camera"`
+	Motion       bool              `json:"motion"`
+	Arming       *arming.State     `json:"arming,omitempty"`
+	Home         *bool             `json:"home,omitempty"`
+	Segments     int               `json:"segments"`
+	Ring         segment.RingStats `json:"ring"`
+	LastSegment  *time.Time        `json:"last_segment,omitempty"`
+	RecentEvents []event.Event     `json:"recent_events"`
+}
+
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s.status())
+}
+
+func (s *Server) status() status {
+	st := status{
+		Camera:       s.opts.Camera,
+		RecentEvents: []event.Event{},
+	}
+
+	segments := s.ring.Window().Segments
+	st.Segments = len(segments)
+	st.Ring = s.ring.Stats()
+	if len(segments) > 0 {
+		st.LastSegment = &segments[len(segments)-1].TS
+	}
+
+	if s.opts.Arming != nil {
+		state := s.opts.Arming.State()
+		home := s.opts.Arming.Home()
+		st.Arming = &state
+		st.Home = &home
+	}
+
+	if s.opts.Status != nil {
+		st.Motion = s.opts.Status.Motion()
+		if events := s.opts.Status.RecentEvents(); events != nil {
+			st.RecentEvents = events
+		}
+	}
+
+	return st
+}
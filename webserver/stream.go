@@ -0,0 +1,69 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// streamKeepalive is how often a comment is sent on an idle stream so
+// proxies don't time it out.
+const streamKeepalive = 15 * time.Second
+
+// streamHandler sends the daemon's feed as server-sent events. Each
+// message is an event named after its type with its data as json:
+//
+//	event: motion_score
+//	data: {"ts":"...","frame":12,"diff":18342,"threshold":20000}
+//
+// Messages are dropped if the client falls behind.
+func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+
+	msgs, unsubscribe := s.opts.Feed.Subscribe(256)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// disable response buffering in nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case msg := <-msgs:
+			var data []byte
+			data, err = json.Marshal(msg.Data)
+			if err != nil {
+				s.lgr.Error("stream_encode_err", "type", msg.Type, "err", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, data)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
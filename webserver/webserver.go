@@ -1,99 +1,283 @@
 package webserver
 
 import (
-	_ "embed"
+	"bytes"
+	"context"
+	"embed"
 	"fmt"
+	"io/fs"
+	"math"
 	"net/http"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/grafov/m3u8"
 	"github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/psanford/rom-cam/arming"
+	"github.com/psanford/rom-cam/config"
+	"github.com/psanford/rom-cam/event"
+	"github.com/psanford/rom-cam/feed"
+	"github.com/psanford/rom-cam/mjpeg"
+	"github.com/psanford/rom-cam/presence"
 	"github.com/psanford/rom-cam/segment"
+	"github.com/psanford/rom-cam/storage"
+	"github.com/psanford/rom-cam/webrtc"
 )
 
-func ListenAndServe(lgr log15.Logger, ring *segment.Ring, ffmpegPath, addr string) error {
+// Options enables optional parts of the webserver.
+type Options struct {
+	// Camera is the camera name shown in the UI.
+	Camera string
+	// Status, if set, adds motion and recent events to GET /status.
+	Status StatusSource
+	// Store, if set, adds uploaded events to the event browser and serves
+	// their media.
+	Store storage.Backend
+	// Location is the timezone for event browser dates.
+	Location *time.Location
+	// Live, if set, enables the low latency HLS playlist /ll.m3u8.
+	Live *segment.Live
+	// TargetDuration is the longest a segment can be. It is the
+	// EXT-X-TARGETDURATION of every playlist, which must not change.
+	// Defaults to defaultTargetDuration.
+	TargetDuration time.Duration
+	// MJPEG, if set, enables /snapshot.jpg and /mjpeg.
+	MJPEG *mjpeg.Decoder
+	// WebRTC, if set, enables WHEP signaling at /whep for the live view.
+	WebRTC *webrtc.Server
+	// Presence, if set, enables POST /presence authenticated with
+	// PresenceToken.
+	Presence      *presence.Endpoint
+	PresenceToken string
+	// Arming, if set, enables GET and POST /arming.
+	Arming *arming.Manager
+	// Config, if set, is served with secrets redacted at /api/v1/config.
+	Config *config.Config
+	// Control, if set, enables detector stats, manual recording and
+	// capture reset in the api.
+	Control Controller
+	// Feed, if set, enables the server-sent event stream at
+	// /api/v1/stream.
+	Feed *feed.Feed
+	// Auth, if set, requires authentication for every endpoint.
+	Auth *config.WebAuth
+	// TLS, if set, serves https instead of http.
+	TLS *config.WebTLS
+}
+
+func ListenAndServe(lgr log15.Logger, ring *segment.Ring, ffmpegPath, addr string, opts Options) error {
+	if opts.TargetDuration <= 0 {
+		opts.TargetDuration = defaultTargetDuration
+	}
+
 	s := &Server{
 		ring:       ring,
 		ffmpegPath: ffmpegPath,
 		lgr:        lgr,
+		opts:       opts,
+	}
+	if _, err := fs.Stat(staticFS, hlsjsPath); err != nil {
+		lgr.Error("hlsjs_missing", "path", "webserver/"+hlsjsPath, "fix", "run go generate ./webserver and rebuild")
+	}
+
+	if opts.Store != nil {
+		s.events = newEventIndex(lgr, opts.Store)
+		go s.events.run(context.Background(), opts.Feed)
 	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.indexHandler)
 	mux.HandleFunc("/playlist.m3u8", s.playlistHandler)
+	mux.HandleFunc("/dvr.m3u8", s.dvrPlaylistHandler)
+
+	if opts.Live != nil {
+		mux.HandleFunc("/ll.m3u8", s.llPlaylistHandler)
+		mux.HandleFunc("/ll/", s.llSegmentHandler)
+	}
+
+	if opts.MJPEG != nil {
+		mux.HandleFunc("/snapshot.jpg", s.snapshotHandler)
+		mux.HandleFunc("/mjpeg", s.mjpegHandler)
+	}
+
+	if opts.WebRTC != nil {
+		mux.HandleFunc("/whep", s.whepHandler)
+		mux.HandleFunc("/whep/", s.whepSessionHandler)
+	}
 	mux.HandleFunc("/segment/", s.segmentHandler)
+	mux.HandleFunc("/status", s.statusHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/events", s.eventsPageHandler)
+	mux.HandleFunc("/events.json", s.eventsJSONHandler)
+
+	if opts.Store != nil {
+		mux.HandleFunc("/media/", s.mediaHandler)
+	}
+
+	static, _ := fs.Sub(staticFS, "static")
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+
+	if opts.Presence != nil {
+		mux.HandleFunc("/presence", s.presenceHandler)
+	}
+
+	if opts.Arming != nil {
+		mux.HandleFunc("/arming", s.armingHandler)
+	}
+
+	s.registerAPI(mux)
+
+	var handler http.Handler = mux
+	if opts.Auth != nil {
+		auth, err := newAuthenticator(*opts.Auth)
+		if err != nil {
+			return err
+		}
+		mux.HandleFunc("/login", auth.loginHandler)
+		mux.HandleFunc("/logout", auth.logoutHandler)
+		mux.HandleFunc("/share", auth.shareHandler)
+		handler = auth.middleware(mux)
+	}
+
+	if opts.TLS == nil {
+		return http.ListenAndServe(addr, handler)
+	}
 
-	return http.ListenAndServe(addr, mux)
+	tlsConf, err := tlsConfig(*opts.TLS)
+	if err != nil {
+		return err
+	}
+
+	if opts.TLS.RedirectAddr != "" {
+		go func() {
+			err := http.ListenAndServe(opts.TLS.RedirectAddr, redirectHandler(addr))
+			if err != nil {
+				lgr.Error("redirect_listen_err", "addr", opts.TLS.RedirectAddr, "err", err)
+			}
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: tlsConf,
+	}
+	return srv.ListenAndServeTLS("", "")
 }
 
 type Server struct {
 	ring       *segment.Ring
 	ffmpegPath string
 	lgr        log15.Logger
+	opts       Options
+
+	eventCacheMu sync.Mutex
+	eventCache   map[string]event.Event
+	// events indexes the event records in storage. It is nil without a
+	// store.
+	events *eventIndex
 }
 
 //go:embed index.html
 var IndexHTML []byte
 
+// staticFS holds the UI assets, including static/hls.min.js which plays hls
+// in browsers without native support. It is downloaded by go generate and
+// is required for hls, LL-HLS and timeshift outside Safari.
+//
+//go:generate go run gen_hlsjs.go
+//go:embed static
+var staticFS embed.FS
+
+// hlsjsPath is where the UI loads hls.js from, relative to staticFS.
+const hlsjsPath = "static/hls.min.js"
+
 func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "text/html; charset=utf-8")
 	w.Write(IndexHTML)
 }
 
+// liveSegments is the number of ring segments listed in the live
+// playlist. The rest of the ring is only in the dvr playlist.
+const liveSegments = 3
+
+// defaultTargetDuration covers a 10s segment that runs on to the next
+// keyframe of a 10s GOP.
+const defaultTargetDuration = 20 * time.Second
+
 func (s *Server) playlistHandler(rw http.ResponseWriter, r *http.Request) {
-	segments := s.ring.Segments()
+	s.writePlaylist(rw, s.ring.Window().Tail(liveSegments))
+}
+
+// dvrPlaylistHandler lists every segment in the ring so players can seek
+// back through all of it. It slides with the ring, so it is a live
+// playlist rather than an EVENT playlist, which may only grow.
+func (s *Server) dvrPlaylistHandler(rw http.ResponseWriter, r *http.Request) {
+	s.writePlaylist(rw, s.ring.Window())
+}
 
-	if len(segments) > 1 {
+// targetDuration is EXT-X-TARGETDURATION in whole seconds.
+func (s *Server) targetDuration() int {
+	return int(math.Ceil(s.opts.TargetDuration.Seconds()))
+}
+
+// clampDuration limits a segment's EXTINF to the target duration, which
+// the spec requires even if a segment ran long.
+func (s *Server) clampDuration(d time.Duration) float64 {
+	return math.Min(d.Seconds(), float64(s.targetDuration()))
+}
+
+func (s *Server) writePlaylist(rw http.ResponseWriter, win segment.RingWindow) {
+	if len(win.Segments) > 1 {
 		// if we have more than 1 segment, report n-1.
 		// this is to avoid reporting on a segment that then gets removed from the ring
 		// before we serve it
-		segments = segments[1:]
+		win = win.Tail(len(win.Segments) - 1)
 	}
 
-	l := uint(len(segments))
+	l := uint(len(win.Segments))
 	p, _ := m3u8.NewMediaPlaylist(l, l)
-	for _, seg := range segments {
-		ts := seg.TS.UnixMicro()
-		duration := float64(seg.Frames) / 10.0
-		p.Append(fmt.Sprintf("/segment/%d", ts), duration, "")
+	p.TargetDuration = float64(s.targetDuration())
+	for _, seg := range win.Segments {
+		p.Append(fmt.Sprintf("/segment/%s.ts", seg.ID), s.clampDuration(seg.Duration), "")
+		if seg.Discontinuity {
+			p.SetDiscontinuity()
+		}
+		p.SetProgramDateTime(seg.TS.UTC())
 	}
 
-	if len(segments) > 0 {
-		p.SeqNo = uint64(segments[len(segments)-1].Idx)
+	if len(win.Segments) > 0 {
+		p.SeqNo = uint64(win.Segments[0].Seq)
 	}
+	p.DiscontinuitySeq = uint64(win.DiscontinuitySeq)
 
+	rw.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	rw.Header().Set("Cache-Control", "no-cache")
 	b := p.Encode()
 	rw.Write(b.Bytes())
 }
 
+// segmentHandler serves GET /segment/<id>.ts. Segment IDs are never
+// reused, so segments can be cached indefinitely.
 func (s *Server) segmentHandler(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) != 3 || parts[1] != "segment" {
+	name := strings.TrimPrefix(r.URL.Path, "/segment/")
+	id := strings.TrimSuffix(name, ".ts")
+	if id == "" || strings.Contains(id, "/") {
 		http.Error(w, "Bad request", 400)
 		return
 	}
 
-	tsStr := parts[2]
-
-	tsMicro, err := strconv.Atoi(tsStr)
-	if err != nil {
-		http.Error(w, "Bad request timestamp", 400)
-		return
-	}
-
-	segments := s.ring.Segments()
-	var foundSeg *segment.Segment
-	for _, seg := range segments {
-		if seg.TS.UnixMicro() == int64(tsMicro) {
-			foundSeg = &seg
-			break
-		}
-	}
-
-	if foundSeg == nil {
+	seg, ok := s.ring.Get(id)
+	if !ok {
 		http.Error(w, "Segment not found", 404)
 		return
 	}
 
-	w.Write(foundSeg.Data)
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+seg.ID+`"`)
+	// ServeContent handles Range and conditional requests
+	http.ServeContent(w, r, name, seg.TS, bytes.NewReader(seg.Data))
 }
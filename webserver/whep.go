@@ -0,0 +1,74 @@
+package webserver
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/psanford/rom-cam/webrtc"
+)
+
+// whepHandler starts a WebRTC session from a WHEP offer:
+//
+//	POST /whep
+//	Content-Type: application/sdp
+//
+// The response is the SDP answer, with a Location to DELETE to end the
+// session. We only offer host candidates up front, so trickle ICE is not
+// supported.
+func (s *Server) whepHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/sdp" {
+		http.Error(w, "Unsupported media type, expected application/sdp", 415)
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	id, answer, err := s.opts.WebRTC.Offer(string(offer))
+	if errors.Is(err, webrtc.ErrTooManySessions) {
+		http.Error(w, "Too many viewers", 503)
+		return
+	} else if err != nil {
+		s.lgr.Error("whep_offer_err", "err", err)
+		http.Error(w, "Bad request "+err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whep/"+id)
+	w.WriteHeader(201)
+	io.WriteString(w, answer)
+}
+
+// whepSessionHandler ends a session with DELETE /whep/<id>.
+func (s *Server) whepSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/whep/")
+	err := s.opts.WebRTC.Close(id)
+	if errors.Is(err, webrtc.ErrSessionNotFound) {
+		http.Error(w, "Session not found", 404)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	w.WriteHeader(200)
+}